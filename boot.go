@@ -51,9 +51,28 @@ func cleanKernel(buildID types.BuildID, bootPrefix string) error {
 var grubTemplate string
 var grubTemplateCompiled = template.Must(template.New("grub").Parse(grubTemplate))
 
+// grubConfig is the data used to render GRUB config.
 type grubConfig struct {
-	StorageRoot string
-	Builds      []types.BuildInfo
+	// Root is the partition kernels are loaded from.
+	Root string
+
+	// Builds are the builds menu entries are generated for.
+	Builds []grubBuild
+}
+
+// grubBuild is the build menu entries are generated for, one per boot option.
+type grubBuild struct {
+	// Name is the name of the build displayed in the menu.
+	Name string
+
+	// KernelDir is the directory containing kernel and initramfs of the build.
+	KernelDir string
+
+	// Params are the kernel params common to all the boot options.
+	Params []string
+
+	// Boots are the boot options of the build.
+	Boots []types.Boot
 }
 
 func generateGRUB(ctx context.Context, storage config.Storage, s storage.Driver) error {
@@ -65,21 +84,27 @@ func generateGRUB(ctx context.Context, storage config.Storage, s storage.Driver)
 		return builds[i].CreatedAt.After(builds[j].CreatedAt)
 	})
 
-	for i, b := range builds {
-		if len(b.Tags) > 0 {
-			builds[i].Name += ":" + string(b.Tags[0])
-		}
-	}
-
 	config := grubConfig{
-		StorageRoot: storage.Root,
-		Builds:      builds,
+		Root:   "(hd0,gpt2)",
+		Builds: make([]grubBuild, 0, len(builds)),
 	}
-	buf := &bytes.Buffer{}
-	if err := grubTemplateCompiled.Execute(buf, config); err != nil {
-		return errors.WithStack(err)
+	for _, b := range builds {
+		config.Builds = append(config.Builds, grubBuild{
+			Name:      grubName(b),
+			KernelDir: "/zfs/" + string(b.BuildID),
+			Params: append([]string{
+				"root=zfs:" + storage.Root + "/" + string(b.BuildID),
+				"bootfs.rollback=image",
+				"zfs.force",
+				"rootdelay=15",
+			}, b.Params...),
+			Boots: b.Boots,
+		})
+	}
+	grubConfig, err := renderGRUB(config)
+	if err != nil {
+		return err
 	}
-	grubConfig := buf.Bytes()
 	return forEachBootMaster(bootPrefix(storage.Root), func(diskMountpoint string) error {
 		grubDir := filepath.Join(diskMountpoint, "grub2")
 		if err := os.WriteFile(filepath.Join(grubDir, "grub.cfg"), grubConfig, 0o644); err != nil {
@@ -90,6 +115,23 @@ func generateGRUB(ctx context.Context, storage config.Storage, s storage.Driver)
 	})
 }
 
+// renderGRUB renders GRUB config from the template.
+func renderGRUB(config grubConfig) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := grubTemplateCompiled.Execute(buf, config); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return buf.Bytes(), nil
+}
+
+// grubName returns the name of the build displayed in GRUB menu.
+func grubName(build types.BuildInfo) string {
+	if len(build.Tags) > 0 {
+		return build.Name + ":" + string(build.Tags[0])
+	}
+	return build.Name
+}
+
 func forEachBootMaster(prefix string, fn func(mountpoint string) error) error {
 	path := "/dev/disk/by-label"
 	files, err := os.ReadDir(path)
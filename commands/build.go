@@ -3,6 +3,8 @@ package commands
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/ridge/must"
 	"github.com/spf13/cobra"
@@ -12,43 +14,86 @@ import (
 	"github.com/outofforest/osman/config"
 	"github.com/outofforest/osman/infra/description"
 	"github.com/outofforest/osman/infra/format"
+	"github.com/outofforest/osman/infra/progress"
 	"github.com/outofforest/osman/infra/types"
 )
 
+// buildFields are printed in the build summary.
+var buildFields = []string{"BuildID", "BasedOn", "CreatedAt", "Name", "Tags", "Usage"}
+
+const (
+	progressPlain = "plain"
+	progressJSON  = "json"
+
+	// specPathEnv is the environment variable containing default spec search path, separated by colons.
+	specPathEnv = "OSMAN_SPEC_PATH"
+)
+
 // NewBuildCommand creates new build command.
 func NewBuildCommand(cmdF *CmdFactory) *cobra.Command {
 	var storageF *config.StorageFactory
 	var formatF *config.FormatFactory
+	var registryF *config.RegistryFactory
 	buildF := &config.BuildFactory{}
 
 	cmd := &cobra.Command{
-		Short: "Builds images from spec files",
+		Short: "Builds images from spec files or images registered by the binary embedding osman",
 		Args:  cobra.MinimumNArgs(1),
-		Use:   "build [flags] ...specfile",
+		Use:   "build [flags] ...(specfile | image)",
 		RunE: cmdF.Cmd(func(c *ioc.Container) {
 			c.Singleton(storageF.Config)
 			c.Singleton(formatF.Config)
+			c.Singleton(registryF.Config)
 			c.Singleton(buildF.Config)
-		}, func(c *ioc.Container, formatter format.Formatter) error {
+		}, func(c *ioc.Container, formatter format.Formatter, build config.Build) error {
 			var builds []types.BuildInfo
 			var err error
 			c.Call(osman.Build, &builds, &err)
 			if err != nil {
 				return err
 			}
-			fmt.Println(formatter.Format(builds, defaultFields...))
+			// In JSON progress mode final build info is reported as an event.
+			if build.Progress != progressJSON {
+				fmt.Println(formatter.Format(builds, buildFields...))
+			}
 			return nil
 		}),
 	}
 	storageF = cmdF.AddStorageFlags(cmd)
 	formatF = cmdF.AddFormatFlags(cmd)
+	registryF = cmdF.AddRegistryFlags(cmd)
 	cmd.Flags().StringSliceVar(&buildF.Names, "name", []string{},
-		"Name of built image, if empty name is derived from corresponding specfile")
-	cmd.Flags().StringSliceVar(&buildF.Tags, "tag", []string{string(description.DefaultTag)},
-		"Tags assigned to created build")
+		"Name of built image, if empty name is derived from corresponding specfile or taken from registered image")
+	cmd.Flags().StringSliceVar(&buildF.Tags, "tag", []string{},
+		"Tags assigned to created build, if empty "+string(description.DefaultTag)+
+			" is used or tags of registered image")
+	cmd.Flags().BoolVar(&buildF.IfStale, "if-stale", false,
+		"Build images only if they don't exist or are out of date, rebuilding stale parents too")
+	cmd.Flags().BoolVar(&buildF.Locked, "locked", false,
+		"Fail if the source of any base image changed since it was initialized, e.g. docker tag was moved")
 	cmd.Flags().BoolVar(&buildF.Rebuild, "rebuild", false,
 		"If set, all parent images are rebuilt even if they exist")
 	cmd.Flags().StringVar(&buildF.CacheDir, "cache-dir", must.String(os.UserCacheDir())+"/osman",
 		"Path to a directory where files are cached")
+	cmd.Flags().StringVar(&buildF.Network, "network", config.NetworkHost,
+		"Default network available to RUN commands: "+config.NetworkHost+" | "+config.NetworkNone)
+	cmd.Flags().StringArrayVar(&buildF.Secrets, "secret", []string{},
+		"Secret available to RUN commands, in the form of id=<id>,src=<path>")
+	cmd.Flags().BoolVar(&buildF.KeepFailed, "keep-failed", false,
+		"If set, failed build is kept and tagged with failed-* tag instead of being dropped, to debug it using shell")
+	cmd.Flags().StringVar(&buildF.Progress, "progress", progressPlain,
+		"Type of progress output: "+strings.Join(cmdF.c.Names((*progress.Reporter)(nil)), " | "))
+	cmd.Flags().DurationVar(&buildF.Timeout, "timeout", 0,
+		"Maximum time the build may take, including all the parent images, e.g. 1h30m; 0 means no timeout")
+	cmd.Flags().Float64Var(&buildF.CPUs, "cpus", 0, "Number of CPUs available to RUN commands; 0 means no limit")
+	cmd.Flags().StringVar(&buildF.Memory, "memory", "",
+		"Maximum amount of memory available to RUN commands, e.g. 512M or 4G; empty means no limit")
+	cmd.Flags().Uint64Var(&buildF.Pids, "pids", 0,
+		"Maximum number of processes RUN commands may create; 0 means no limit")
+	cmd.Flags().StringSliceVar(&buildF.SpecPath, "spec-path", filepath.SplitList(os.Getenv(specPathEnv)),
+		"Directories where spec files of parent images are searched for if they don't exist in the directory "+
+			"of built spec file, defaults to $"+specPathEnv)
+	cmd.Flags().StringArrayVar(&buildF.Labels, "label", []string{},
+		"Label in the form of key=value set on built images, overriding the one defined by spec file")
 	return cmd
 }
@@ -0,0 +1,93 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/ridge/must"
+	"github.com/spf13/cobra"
+
+	"github.com/outofforest/ioc/v2"
+	"github.com/outofforest/osman"
+	"github.com/outofforest/osman/config"
+	"github.com/outofforest/osman/infra/cache"
+	"github.com/outofforest/osman/infra/format"
+)
+
+// NewCacheCommand creates new cache command.
+func NewCacheCommand(cmdF *CmdFactory) *cobra.Command {
+	cmd := &cobra.Command{
+		Short: "Manages persistent caches mounted into RUN commands",
+		Use:   "cache",
+	}
+	cmd.AddCommand(newCacheListCommand(cmdF), newCachePruneCommand(cmdF))
+	return cmd
+}
+
+func newCacheListCommand(cmdF *CmdFactory) *cobra.Command {
+	var formatF *config.FormatFactory
+	cacheF := &config.CacheFactory{}
+
+	cmd := &cobra.Command{
+		Short: "Lists caches together with their sizes",
+		Args:  cobra.NoArgs,
+		Use:   "list [flags]",
+		RunE: cmdF.Cmd(func(c *ioc.Container) {
+			c.Singleton(formatF.Config)
+			c.Singleton(cacheF.Config)
+		}, func(c *ioc.Container, formatter format.Formatter) error {
+			var caches []cache.Info
+			var err error
+			c.Call(osman.CacheList, &caches, &err)
+			if err != nil {
+				return err
+			}
+			fmt.Println(formatter.Format(caches))
+			return nil
+		}),
+	}
+	formatF = cmdF.AddFormatFlags(cmd)
+	addCacheDirFlag(cmd, cacheF)
+	return cmd
+}
+
+func newCachePruneCommand(cmdF *CmdFactory) *cobra.Command {
+	var formatF *config.FormatFactory
+	cacheF := &config.CacheFactory{}
+
+	cmd := &cobra.Command{
+		Short: "Deletes caches",
+		Use:   "prune [flags] [... id]",
+		RunE: cmdF.Cmd(func(c *ioc.Container) {
+			c.Singleton(formatF.Config)
+			c.Singleton(cacheF.Config)
+		}, func(c *ioc.Container, formatter format.Formatter) error {
+			var results []cache.PruneResult
+			var err error
+			c.Call(osman.CachePrune, &results, &err)
+			if err != nil {
+				return err
+			}
+			err = nil
+			for _, r := range results {
+				if r.Result != nil {
+					err = errors.New("some prunes failed")
+					break
+				}
+			}
+			fmt.Println(formatter.Format(results))
+			return err
+		}),
+	}
+	formatF = cmdF.AddFormatFlags(cmd)
+	addCacheDirFlag(cmd, cacheF)
+	cmd.Flags().BoolVar(&cacheF.All, "all", false,
+		"It is required to set this flag to prune caches if no IDs are provided")
+	return cmd
+}
+
+func addCacheDirFlag(cmd *cobra.Command, cacheF *config.CacheFactory) {
+	cmd.Flags().StringVar(&cacheF.CacheDir, "cache-dir", must.String(os.UserCacheDir())+"/osman",
+		"Path to a directory where files are cached")
+}
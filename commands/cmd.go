@@ -1,6 +1,7 @@
 package commands
 
 import (
+	"fmt"
 	"os"
 	"strings"
 
@@ -52,6 +53,22 @@ func (f *CmdFactory) AddStorageFlags(cmd *cobra.Command) *config.StorageFactory
 	return storageF
 }
 
+// AddRegistryFlags adds docker registry flags to command.
+func (f *CmdFactory) AddRegistryFlags(cmd *cobra.Command) *config.RegistryFactory {
+	registryF := &config.RegistryFactory{}
+
+	cmd.Flags().StringVar(&registryF.ConfigFile, "registry-config", "",
+		"Docker-style config.json file containing credentials to registries, defaults to "+
+			"$DOCKER_CONFIG/config.json or ~/.docker/config.json")
+	cmd.Flags().StringArrayVar(&registryF.Mirrors, "registry-mirror", []string{},
+		"Mirror of the registry in the form of host=url, e.g. docker.io=https://mirror.example.com, "+
+			"mirrors are tried in order before the registry itself")
+	cmd.Flags().StringArrayVar(&registryF.Insecure, "insecure-registry", []string{},
+		"Registry host accessed using plain HTTP, e.g. localhost:5000")
+
+	return registryF
+}
+
 // AddFilterFlags adds filtering flags to command.
 func (f *CmdFactory) AddFilterFlags(cmd *cobra.Command, defaultTypes []string) *config.FilterFactory {
 	filterF := &config.FilterFactory{}
@@ -60,6 +77,8 @@ func (f *CmdFactory) AddFilterFlags(cmd *cobra.Command, defaultTypes []string) *
 		"Consider only builds of specified types: "+strings.Join(config.BuildTypes(), " | "))
 	cmd.Flags().BoolVar(&filterF.Untagged, "untagged", false,
 		"If set, only untagged builds are considered")
+	cmd.Flags().StringArrayVar(&filterF.Labels, "label", []string{},
+		"Consider only builds having label, in the form of key=value or key to match any value")
 
 	return filterF
 }
@@ -73,3 +92,13 @@ func (f *CmdFactory) AddFormatFlags(cmd *cobra.Command) *config.FormatFactory {
 
 	return formatF
 }
+
+// ExitError is returned by command which should make osman exit with the exit code, without reporting an error.
+type ExitError struct {
+	Code int
+}
+
+// Error returns error message.
+func (e ExitError) Error() string {
+	return fmt.Sprintf("exit code %d", e.Code)
+}
@@ -3,8 +3,10 @@ package commands
 
 import (
 	"fmt"
+	"os"
 
 	"github.com/pkg/errors"
+	"github.com/ridge/must"
 	"github.com/spf13/cobra"
 
 	"github.com/outofforest/ioc/v2"
@@ -51,6 +53,8 @@ func NewDropCommand(cmdF *CmdFactory) *cobra.Command {
 	formatF = cmdF.AddFormatFlags(cmd)
 	cmd.Flags().StringVar(&dropF.LibvirtAddr, "libvirt-addr", "unix:///var/run/libvirt/libvirt-sock",
 		"Address libvirt listens on")
+	cmd.Flags().StringVar(&dropF.CacheDir, "cache-dir", must.String(os.UserCacheDir())+"/osman",
+		"Path to a directory where files are cached")
 	cmd.Flags().BoolVar(&dropF.All, "all", false,
 		"It is required to set this flag to drop builds if no filters are provided")
 	return cmd
@@ -0,0 +1,51 @@
+package commands
+
+import (
+	"context"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	"github.com/outofforest/ioc/v2"
+	"github.com/outofforest/logger"
+	"github.com/outofforest/osman"
+	"github.com/outofforest/osman/config"
+	"github.com/outofforest/osman/infra/export"
+	"github.com/outofforest/osman/infra/types"
+)
+
+// NewExportCommand creates new export command.
+func NewExportCommand(cmdF *CmdFactory) *cobra.Command {
+	var storageF *config.StorageFactory
+	exportF := &config.ExportFactory{}
+
+	cmd := &cobra.Command{
+		Short: "Exports build to be used by other tools",
+		Args:  cobra.ExactArgs(1),
+		Use:   "export [flags] (buildID | name[:tag])",
+		RunE: cmdF.Cmd(func(c *ioc.Container) {
+			c.Singleton(storageF.Config)
+			c.Singleton(exportF.Config)
+		}, func(ctx context.Context, c *ioc.Container, exportConfig config.Export) error {
+			var info types.BuildInfo
+			var err error
+			c.Call(osman.Export, &info, &err)
+			if err != nil {
+				return err
+			}
+			// Stdout might be used as the output, so result is logged only.
+			logger.Get(ctx).Info("Build exported", zap.String("buildID", string(info.BuildID)),
+				zap.String("format", exportConfig.Format), zap.String("output", exportConfig.Output))
+			return nil
+		}),
+	}
+	storageF = cmdF.AddStorageFlags(cmd)
+	cmd.Flags().StringVar(&exportF.Format, "format", "oci",
+		"Format of exported build: "+strings.Join(cmdF.c.Names((*export.Exporter)(nil)), " | "))
+	cmd.Flags().StringVar(&exportF.Output, "output", "",
+		"Path where build is exported to, "+config.ExportStdout+" means standard output if format supports it")
+	cmd.Flags().BoolVar(&exportF.Layers, "layers", false,
+		"Export each ancestor of the build as a separate layer, if format supports it")
+	return cmd
+}
@@ -0,0 +1,113 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/outofforest/ioc/v2"
+	"github.com/outofforest/osman"
+	"github.com/outofforest/osman/config"
+	"github.com/outofforest/osman/infra/format"
+	"github.com/outofforest/osman/infra/types"
+)
+
+const formatJSON = "json"
+
+// NewInspectCommand creates new inspect command.
+func NewInspectCommand(cmdF *CmdFactory) *cobra.Command {
+	var storageF *config.StorageFactory
+	var formatF *config.FormatFactory
+	inspectF := &config.InspectFactory{}
+
+	cmd := &cobra.Command{
+		Short: "Shows how the build was produced, its ancestors and children",
+		Args:  cobra.ExactArgs(1),
+		Use:   "inspect [flags] (buildID | name[:tag])",
+		RunE: cmdF.Cmd(func(c *ioc.Container) {
+			c.Singleton(storageF.Config)
+			c.Singleton(formatF.Config)
+			c.Singleton(inspectF.Config)
+		}, func(c *ioc.Container, formatter format.Formatter, formatConfig config.Format) error {
+			var inspection osman.Inspection
+			var err error
+			c.Call(osman.Inspect, &inspection, &err)
+			if err != nil {
+				return err
+			}
+			if formatConfig.Formatter == formatJSON {
+				fmt.Println(formatter.Format(inspection))
+				return nil
+			}
+			fmt.Println(formatInspection(inspection, formatter))
+			return nil
+		}),
+	}
+	storageF = cmdF.AddStorageFlags(cmd)
+	formatF = cmdF.AddFormatFlags(cmd)
+	return cmd
+}
+
+func formatInspection(inspection osman.Inspection, formatter format.Formatter) string {
+	const timeFormat = "2006-01-02 15:04:05"
+
+	b := &strings.Builder{}
+	build := inspection.Build
+	fmt.Fprintf(b, "BuildID:    %s\n", build.BuildID)
+	fmt.Fprintf(b, "Name:       %s\n", build.Name)
+	fmt.Fprintf(b, "Tags:       %s\n", build.Tags)
+	fmt.Fprintf(b, "CreatedAt:  %s\n", build.CreatedAt.Local().Format(timeFormat))
+	if len(build.Labels) > 0 {
+		fmt.Fprintf(b, "Labels:     %s\n", build.Labels)
+	}
+	if build.Usage != (types.Usage{}) {
+		fmt.Fprintf(b, "Usage:      %s\n", build.Usage)
+	}
+
+	if build.Base != nil {
+		fmt.Fprintf(b, "Base:       %s", build.Base.Reference)
+		if build.Base.Digest != "" {
+			fmt.Fprintf(b, " (%s)", build.Base.Digest)
+		}
+		b.WriteString("\n")
+	}
+
+	if p := build.Provenance; p != nil {
+		b.WriteString("\nPROVENANCE\n")
+		if p.SpecFile != "" {
+			fmt.Fprintf(b, "Spec file:  %s (%s)\n", p.SpecFile, p.SpecDigest)
+		}
+		for _, include := range p.Includes {
+			fmt.Fprintf(b, "Includes:   %s\n", include)
+		}
+		fmt.Fprintf(b, "From:       %s (%s)\n", p.From, p.FromBuildID)
+		fmt.Fprintf(b, "Builder:    %s\n", p.BuilderVersion)
+		fmt.Fprintf(b, "Built by:   %s@%s\n", p.User, p.Host)
+		for _, cmd := range p.Commands {
+			fmt.Fprintf(b, "COMMAND [%s] %s\n", cmd.Source, cmd.Command)
+		}
+		for _, step := range p.Steps {
+			fmt.Fprintf(b, "STEP %d [%s] %s (%s)\n", step.Step, step.Source, step.Command, step.Duration)
+		}
+	}
+
+	if len(build.Tests) > 0 {
+		b.WriteString("\nTESTS\n")
+		for _, test := range build.Tests {
+			status := "PASSED"
+			if !test.Passed {
+				status = "FAILED: " + test.Error
+			}
+			fmt.Fprintf(b, "%s (%s) %s\n", test.Test, test.Duration, status)
+		}
+	}
+
+	if len(inspection.Lineage) > 0 {
+		fmt.Fprintf(b, "\nLINEAGE\n%s\n", formatter.Format(inspection.Lineage, defaultFields...))
+	}
+	if len(inspection.Children) > 0 {
+		fmt.Fprintf(b, "\nCHILDREN\n%s\n", formatter.Format(inspection.Children, defaultFields...))
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
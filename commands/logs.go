@@ -0,0 +1,65 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/ridge/must"
+	"github.com/spf13/cobra"
+
+	"github.com/outofforest/ioc/v2"
+	"github.com/outofforest/osman"
+	"github.com/outofforest/osman/config"
+	"github.com/outofforest/osman/infra/buildlog"
+	"github.com/outofforest/osman/infra/storage"
+)
+
+// NewLogsCommand creates new logs command.
+func NewLogsCommand(cmdF *CmdFactory) *cobra.Command {
+	var storageF *config.StorageFactory
+	logsF := &config.LogsFactory{}
+
+	cmd := &cobra.Command{
+		Short: "Prints logs of the build",
+		Args:  cobra.ExactArgs(1),
+		Use:   "logs [flags] (buildID | name[:tag])",
+		RunE: cmdF.Cmd(func(c *ioc.Container) {
+			c.Singleton(storageF.Config)
+			c.Singleton(logsF.Config)
+		}, func(ctx context.Context, logs config.Logs, s storage.Driver) error {
+			return osman.Logs(ctx, logs, s, printLogRecord)
+		}),
+	}
+	storageF = cmdF.AddStorageFlags(cmd)
+	cmd.Flags().StringVar(&logsF.CacheDir, "cache-dir", must.String(os.UserCacheDir())+"/osman",
+		"Path to a directory where files are cached")
+	cmd.Flags().IntVar(&logsF.Step, "step", 0, "If set, only logs of this step are printed")
+	cmd.Flags().BoolVar(&logsF.Follow, "follow", false, "If set, new logs are printed until build finishes")
+	return cmd
+}
+
+func printLogRecord(record buildlog.Record) error {
+	const timeFormat = "2006-01-02 15:04:05"
+
+	var err error
+	switch record.Type {
+	case buildlog.RecordStep:
+		_, err = fmt.Printf("STEP %d [%s]: %s\n", record.Step, record.Source, record.Command)
+	case buildlog.RecordLog:
+		_, err = fmt.Printf("%s | %s\n", record.Time.Local().Format(timeFormat), record.Content)
+	case buildlog.RecordStepEnd:
+		if record.Error != "" {
+			_, err = fmt.Printf("STEP %d failed after %s: %s\n", record.Step, record.Duration, record.Error)
+		} else {
+			_, err = fmt.Printf("STEP %d finished in %s\n", record.Step, record.Duration)
+		}
+	case buildlog.RecordEnd:
+		if record.Error != "" {
+			_, err = fmt.Printf("BUILD FAILED: %s\n", record.Error)
+		} else {
+			_, err = fmt.Println("BUILD SUCCEEDED")
+		}
+	}
+	return err
+}
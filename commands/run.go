@@ -0,0 +1,46 @@
+package commands
+
+import (
+	"context"
+
+	"github.com/spf13/cobra"
+
+	"github.com/outofforest/ioc/v2"
+	"github.com/outofforest/osman"
+	"github.com/outofforest/osman/config"
+	"github.com/outofforest/osman/infra/storage"
+)
+
+// NewRunCommand creates new run command.
+func NewRunCommand(cmdF *CmdFactory) *cobra.Command {
+	var storageF *config.StorageFactory
+	runF := &config.RunFactory{}
+
+	cmd := &cobra.Command{
+		Short: "Runs command inside the temporary clone of the image and exits with its exit code",
+		Args:  cobra.MinimumNArgs(2),
+		Use:   "run [flags] (buildID | name[:tag]) -- command [args...]",
+		RunE: cmdF.Cmd(func(c *ioc.Container) {
+			c.Singleton(storageF.Config)
+			c.Singleton(runF.Config)
+		}, func(ctx context.Context, run config.Run, s storage.Driver) error {
+			exitCode, err := osman.Run(ctx, run, s)
+			if err != nil {
+				return err
+			}
+			if exitCode != 0 {
+				return ExitError{Code: exitCode}
+			}
+			return nil
+		}),
+	}
+	storageF = cmdF.AddStorageFlags(cmd)
+	cmd.Flags().StringVar(&runF.Network, "network", config.NetworkHost,
+		"Network available to the command: "+config.NetworkHost+" | "+config.NetworkNone)
+	cmd.Flags().StringArrayVar(&runF.Mounts, "mount", []string{},
+		"Host directory mounted into the image, in the form of source:target[:ro]")
+	cmd.Flags().StringVar(&runF.WorkDir, "workdir", "/", "Working directory of the command")
+	cmd.Flags().BoolVar(&runF.Keep, "keep", false, "If set, clone of the image is kept after command exits")
+	cmd.Flags().StringSliceVar(&runF.Tags, "tag", []string{}, "Tags applied to the kept clone")
+	return cmd
+}
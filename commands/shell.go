@@ -0,0 +1,40 @@
+package commands
+
+import (
+	"context"
+	"os"
+
+	"github.com/ridge/must"
+	"github.com/spf13/cobra"
+
+	"github.com/outofforest/ioc/v2"
+	"github.com/outofforest/osman"
+	"github.com/outofforest/osman/config"
+	"github.com/outofforest/osman/infra/storage"
+)
+
+// NewShellCommand creates new shell command.
+func NewShellCommand(cmdF *CmdFactory) *cobra.Command {
+	var storageF *config.StorageFactory
+	shellF := &config.ShellFactory{}
+
+	cmd := &cobra.Command{
+		Short: "Opens interactive shell inside the temporary clone of the image or kept failed build",
+		Args:  cobra.MinimumNArgs(1),
+		Use:   "shell [flags] (buildID | name[:tag]) [-- command [args...]]",
+		RunE: cmdF.Cmd(func(c *ioc.Container) {
+			c.Singleton(storageF.Config)
+			c.Singleton(shellF.Config)
+		}, func(ctx context.Context, shell config.Shell, s storage.Driver) error {
+			return osman.Shell(ctx, shell, s)
+		}),
+	}
+	storageF = cmdF.AddStorageFlags(cmd)
+	cmd.Flags().StringVar(&shellF.CacheDir, "cache-dir", must.String(os.UserCacheDir())+"/osman",
+		"Path to a directory where files are cached")
+	cmd.Flags().StringVar(&shellF.Network, "network", config.NetworkHost,
+		"Network available to the shell: "+config.NetworkHost+" | "+config.NetworkNone)
+	cmd.Flags().StringArrayVar(&shellF.Secrets, "secret", []string{},
+		"Secret mounted into the shell, in the form of id=<id>,src=<path>")
+	return cmd
+}
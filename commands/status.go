@@ -0,0 +1,42 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/outofforest/ioc/v2"
+	"github.com/outofforest/osman"
+	"github.com/outofforest/osman/config"
+	"github.com/outofforest/osman/infra/format"
+)
+
+// NewStatusCommand creates new status command.
+func NewStatusCommand(cmdF *CmdFactory) *cobra.Command {
+	var storageF *config.StorageFactory
+	var filterF *config.FilterFactory
+	var formatF *config.FormatFactory
+
+	cmd := &cobra.Command{
+		Short: "Lists images which are out of date because their spec file or parent changed",
+		Use:   "status [flags] [... buildID | [name][:tag]]",
+		RunE: cmdF.Cmd(func(c *ioc.Container) {
+			c.Singleton(storageF.Config)
+			c.Singleton(filterF.Config)
+			c.Singleton(formatF.Config)
+		}, func(c *ioc.Container, formatter format.Formatter) error {
+			var builds []osman.StaleBuild
+			var err error
+			c.Call(osman.Status, &builds, &err)
+			if err != nil {
+				return err
+			}
+			fmt.Println(formatter.Format(builds))
+			return nil
+		}),
+	}
+	storageF = cmdF.AddStorageFlags(cmd)
+	filterF = cmdF.AddFilterFlags(cmd, []string{config.BuildTypeImage})
+	formatF = cmdF.AddFormatFlags(cmd)
+	return cmd
+}
@@ -4,12 +4,23 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
+	"github.com/pkg/errors"
 	"github.com/ridge/must"
 
+	"github.com/outofforest/osman/infra/description"
 	"github.com/outofforest/osman/infra/types"
 )
 
+const (
+	// NetworkHost gives commands access to the network of the host.
+	NetworkHost = "host"
+
+	// NetworkNone disables network access for commands.
+	NetworkNone = "none"
+)
+
 // BuildFactory collects data for build config.
 type BuildFactory struct {
 	// Names is the list of names for corresponding specfiles.
@@ -21,8 +32,45 @@ type BuildFactory struct {
 	// Rebuild forces rebuild of all parent images even if they exist.
 	Rebuild bool
 
+	// KeepFailed keeps failed builds tagged with failed-* tag instead of dropping them.
+	KeepFailed bool
+
+	// IfStale builds images only if they don't exist or are out of date, rebuilding stale parents too.
+	IfStale bool
+
+	// Locked fails the build if the source of any base image changed since it was initialized,
+	// e.g. docker tag points to a different digest.
+	Locked bool
+
 	// CacheDir is the directory where cached files are stored.
 	CacheDir string
+
+	// Network is the default network available to RUN commands.
+	Network string
+
+	// Secrets is the list of secrets in the form of `id=<id>,src=<path>`.
+	Secrets []string
+
+	// Progress is the name of reporter used to report build progress.
+	Progress string
+
+	// Timeout is the maximum time the build may take. Zero means no timeout.
+	Timeout time.Duration
+
+	// CPUs is the number of CPUs available to RUN commands.
+	CPUs float64
+
+	// Memory is the maximum amount of memory available to RUN commands, e.g. 512M or 4G.
+	Memory string
+
+	// Pids is the maximum number of processes RUN commands may create.
+	Pids uint64
+
+	// Labels is the list of labels in the form of key=value set on built images.
+	Labels []string
+
+	// SpecPath is the list of directories where spec files of parent images are searched for.
+	SpecPath []string
 }
 
 // Config creates build config.
@@ -30,21 +78,62 @@ func (f BuildFactory) Config(args Args) Build {
 	must.OK(os.MkdirAll(f.CacheDir, 0o700))
 
 	config := Build{
-		SpecFiles: args,
-		Names:     f.Names,
-		Tags:      make(types.Tags, 0, len(f.Tags)),
-		Rebuild:   f.Rebuild,
-		CacheDir:  must.String(filepath.Abs(must.String(filepath.EvalSymlinks(f.CacheDir)))),
+		SpecFiles:  args,
+		Names:      f.Names,
+		Tags:       make(types.Tags, 0, len(f.Tags)),
+		Rebuild:    f.Rebuild,
+		KeepFailed: f.KeepFailed,
+		IfStale:    f.IfStale,
+		Locked:     f.Locked,
+		CacheDir:   must.String(filepath.Abs(must.String(filepath.EvalSymlinks(f.CacheDir)))),
+		Network:    f.Network,
+		Secrets:    make(map[string]string, len(f.Secrets)),
+		Labels:     make(types.Labels, len(f.Labels)),
+		SpecPath:   make([]string, 0, len(f.SpecPath)),
+		Progress:   f.Progress,
+		Timeout:    f.Timeout,
 	}
-
-	for i, specFile := range config.SpecFiles {
-		if len(config.Names) < i+1 {
-			config.Names = append(config.Names, strings.TrimSuffix(filepath.Base(specFile), ".spec"))
-		}
+	if config.Rebuild && config.IfStale {
+		panic(errors.New("rebuild and if-stale can't be used together"))
+	}
+	if config.Timeout < 0 {
+		panic(errors.Errorf("timeout '%s' is invalid", config.Timeout))
 	}
+	if f.CPUs < 0 {
+		panic(errors.Errorf("number of cpus '%g' is invalid", f.CPUs))
+	}
+	config.Limits = types.Limits{
+		CPUs: f.CPUs,
+		Pids: f.Pids,
+	}
+	if f.Memory != "" {
+		config.Limits.Memory = must.Uint64(types.ParseMemory(f.Memory))
+	}
+	if config.Network != NetworkHost && config.Network != NetworkNone {
+		panic(errors.Errorf("network '%s' is invalid", config.Network))
+	}
+
 	for _, tag := range f.Tags {
 		config.Tags = append(config.Tags, types.Tag(tag))
 	}
+	for _, dir := range f.SpecPath {
+		// Builder changes working directory, so paths must be absolute.
+		config.SpecPath = append(config.SpecPath, must.String(filepath.Abs(dir)))
+	}
+	for _, label := range f.Labels {
+		key, value, err := types.ParseLabel(label, true)
+		if err != nil {
+			panic(err)
+		}
+		config.Labels[key] = value
+	}
+	for _, secret := range f.Secrets {
+		id, src := parseSecret(secret)
+		if _, exists := config.Secrets[id]; exists {
+			panic(errors.Errorf("secret %s is defined more than once", id))
+		}
+		config.Secrets[id] = src
+	}
 	return config
 }
 
@@ -53,15 +142,76 @@ type Build struct {
 	// SpecFiles is the list of specfiles to build.
 	SpecFiles []string
 
-	// Names is the list of names for corresponding specfiles.
+	// Names is the list of names for corresponding specfiles. If name is missing, it is derived from the specfile
+	// or taken from the registered image.
 	Names []string
 
-	// Tags are used to tag the build.
+	// Tags are used to tag the build. If empty, registered image is tagged with its own tags
+	// and image built from specfile with the default tag.
 	Tags types.Tags
 
 	// Rebuild forces rebuild of all parent images even if they exist.
 	Rebuild bool
 
+	// KeepFailed keeps failed builds tagged with failed-* tag instead of dropping them.
+	KeepFailed bool
+
+	// IfStale builds images only if they don't exist or are out of date, rebuilding stale parents too.
+	IfStale bool
+
+	// Locked fails the build if the source of any base image changed since it was initialized,
+	// e.g. docker tag points to a different digest.
+	Locked bool
+
 	// CacheDir is the directory where cached files are stored.
 	CacheDir string
+
+	// Network is the default network available to RUN commands.
+	Network string
+
+	// Secrets maps secret IDs to files containing them.
+	Secrets map[string]string
+
+	// Progress is the name of reporter used to report build progress.
+	Progress string
+
+	// Timeout is the maximum time the build may take. Zero means no timeout.
+	Timeout time.Duration
+
+	// Limits are the default resource limits of RUN commands.
+	Limits types.Limits
+
+	// Labels are set on built images, overriding the ones defined by spec files.
+	Labels types.Labels
+
+	// SpecPath is the list of directories where spec files of parent images are searched for.
+	SpecPath []string
+}
+
+func parseSecret(secret string) (string, string) {
+	var id, src string
+	for _, field := range strings.Split(secret, ",") {
+		k, v, ok := strings.Cut(field, "=")
+		if !ok || v == "" {
+			panic(errors.Errorf("invalid secret option '%s'", field))
+		}
+		switch k {
+		case "id":
+			id = v
+		case "src", "source":
+			src = v
+		default:
+			panic(errors.Errorf("unknown secret option '%s'", k))
+		}
+	}
+	if id == "" {
+		panic(errors.Errorf("id is missing in secret '%s'", secret))
+	}
+	if !description.IsMountIDValid(id) {
+		panic(errors.Errorf("id '%s' of secret is invalid", id))
+	}
+	if src == "" {
+		panic(errors.Errorf("source is missing in secret '%s'", secret))
+	}
+	return id, must.String(filepath.Abs(src))
 }
@@ -0,0 +1,31 @@
+package config
+
+// CacheFactory collects data for cache config.
+type CacheFactory struct {
+	// CacheDir is the directory where cached files are stored.
+	CacheDir string
+
+	// If no ID is provided it is required to set this flag to prune caches.
+	All bool
+}
+
+// Config returns new cache config.
+func (f *CacheFactory) Config(args Args) Cache {
+	return Cache{
+		CacheDir: f.CacheDir,
+		All:      f.All,
+		IDs:      args,
+	}
+}
+
+// Cache stores configuration of cache command.
+type Cache struct {
+	// CacheDir is the directory where cached files are stored.
+	CacheDir string
+
+	// If no ID is provided it is required to set this flag to prune caches.
+	All bool
+
+	// IDs is the list of caches to operate on.
+	IDs []string
+}
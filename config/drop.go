@@ -7,6 +7,9 @@ type DropFactory struct {
 
 	// LibvirtAddr is the address libvirt listens on.
 	LibvirtAddr string
+
+	// CacheDir is the directory where cached files are stored.
+	CacheDir string
 }
 
 // Config returns new drop config.
@@ -14,6 +17,7 @@ func (f *DropFactory) Config() Drop {
 	return Drop{
 		All:         f.All,
 		LibvirtAddr: f.LibvirtAddr,
+		CacheDir:    f.CacheDir,
 	}
 }
 
@@ -24,4 +28,7 @@ type Drop struct {
 
 	// LibvirtAddr is the address libvirt listens on.
 	LibvirtAddr string
+
+	// CacheDir is the directory where cached files are stored.
+	CacheDir string
 }
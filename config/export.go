@@ -0,0 +1,56 @@
+package config
+
+import (
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/ridge/must"
+)
+
+// ExportStdout is the output meaning that the export is written to standard output.
+const ExportStdout = "-"
+
+// ExportFactory collects data for export config.
+type ExportFactory struct {
+	// Format is the name of exporter to use.
+	Format string
+
+	// Output is the path where build is exported to.
+	Output string
+
+	// Layers exports each ancestor of the build as a separate layer.
+	Layers bool
+}
+
+// Config returns new export config.
+func (f *ExportFactory) Config(args Args) Export {
+	if f.Output == "" {
+		panic(errors.New("output is not specified"))
+	}
+
+	config := Export{
+		Build:  args[0],
+		Format: f.Format,
+		Output: f.Output,
+		Layers: f.Layers,
+	}
+	if config.Output != ExportStdout {
+		config.Output = must.String(filepath.Abs(config.Output))
+	}
+	return config
+}
+
+// Export stores configuration of export command.
+type Export struct {
+	// Build is the build ID or build key of the exported build.
+	Build string
+
+	// Format is the name of exporter to use.
+	Format string
+
+	// Output is the path where build is exported to.
+	Output string
+
+	// Layers exports each ancestor of the build as a separate layer.
+	Layers bool
+}
@@ -2,6 +2,7 @@ package config
 
 import (
 	"sort"
+	"strings"
 
 	"github.com/pkg/errors"
 
@@ -46,6 +47,9 @@ type FilterFactory struct {
 
 	// Types is the list of build types to return.
 	Types []string
+
+	// Labels is the list of labels in the form of key=value or key builds must have.
+	Labels []string
 }
 
 // Config returns new filter config.
@@ -55,6 +59,7 @@ func (f *FilterFactory) Config(args Args) Filter {
 		Types:     make([]types.BuildType, 0, len(f.Types)),
 		BuildIDs:  make([]types.BuildID, 0, len(args)),
 		BuildKeys: make([]types.BuildKey, 0, len(args)),
+		Labels:    types.Labels{},
 	}
 	for _, t := range f.Types {
 		buildType, exists := typeMapping[t]
@@ -64,6 +69,18 @@ func (f *FilterFactory) Config(args Args) Filter {
 		config.Types = append(config.Types, buildType)
 	}
 
+	for _, label := range f.Labels {
+		key, value, err := types.ParseLabel(label, false)
+		if err != nil {
+			panic(err)
+		}
+		if !strings.Contains(label, "=") {
+			config.LabelKeys = append(config.LabelKeys, key)
+			continue
+		}
+		config.Labels[key] = value
+	}
+
 	for _, arg := range args {
 		buildID, err := types.ParseBuildID(arg)
 		if err == nil {
@@ -93,4 +110,15 @@ type Filter struct {
 
 	// BuildKeys is the list of build keys to return
 	BuildKeys []types.BuildKey
+
+	// Labels are the labels builds must have with the same values
+	Labels types.Labels
+
+	// LabelKeys are the labels builds must have with any value
+	LabelKeys []string
+}
+
+// HasCriteria returns true if builds are selected by IDs, keys or labels.
+func (f Filter) HasCriteria() bool {
+	return len(f.BuildIDs) > 0 || len(f.BuildKeys) > 0 || len(f.Labels) > 0 || len(f.LabelKeys) > 0
 }
@@ -0,0 +1,17 @@
+package config
+
+// InspectFactory collects data for inspect config.
+type InspectFactory struct{}
+
+// Config returns new inspect config.
+func (f *InspectFactory) Config(args Args) Inspect {
+	return Inspect{
+		Build: args[0],
+	}
+}
+
+// Inspect stores configuration of inspect command.
+type Inspect struct {
+	// Build is the build ID or build key of the inspected build.
+	Build string
+}
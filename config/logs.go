@@ -0,0 +1,38 @@
+package config
+
+// LogsFactory collects data for logs config.
+type LogsFactory struct {
+	// CacheDir is the directory where cached files are stored.
+	CacheDir string
+
+	// Step limits logs to the one of the step.
+	Step int
+
+	// Follow waits for new logs until build finishes.
+	Follow bool
+}
+
+// Config returns new logs config.
+func (f *LogsFactory) Config(args Args) Logs {
+	return Logs{
+		CacheDir: f.CacheDir,
+		Step:     f.Step,
+		Follow:   f.Follow,
+		Build:    args[0],
+	}
+}
+
+// Logs stores configuration of logs command.
+type Logs struct {
+	// CacheDir is the directory where cached files are stored.
+	CacheDir string
+
+	// Step limits logs to the one of the step.
+	Step int
+
+	// Follow waits for new logs until build finishes.
+	Follow bool
+
+	// Build is the build ID or build key of the build.
+	Build string
+}
@@ -0,0 +1,147 @@
+package config
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/ridge/must"
+)
+
+// dockerConfigEnv is the environment variable pointing to the directory containing docker config file.
+const dockerConfigEnv = "DOCKER_CONFIG"
+
+// RegistryFactory collects data for registry config.
+type RegistryFactory struct {
+	// ConfigFile is the docker-style config.json file containing credentials to registries.
+	// If empty, the default docker config file is used, if it exists.
+	ConfigFile string
+
+	// Mirrors is the list of mirrors in the form of host=url.
+	Mirrors []string
+
+	// Insecure is the list of registry hosts accessed using plain HTTP.
+	Insecure []string
+}
+
+// Config returns new registry config.
+func (f *RegistryFactory) Config() Registry {
+	config := Registry{
+		Mirrors:  map[string][]string{},
+		Insecure: map[string]bool{},
+		Auths:    map[string]RegistryAuth{},
+	}
+	for _, mirror := range f.Mirrors {
+		host, url, ok := strings.Cut(mirror, "=")
+		if !ok || host == "" || url == "" {
+			panic(errors.Errorf("mirror '%s' is invalid, expected form is host=url", mirror))
+		}
+		host = RegistryHost(host)
+		config.Mirrors[host] = append(config.Mirrors[host], strings.TrimSuffix(url, "/"))
+	}
+	for _, host := range f.Insecure {
+		config.Insecure[RegistryHost(host)] = true
+	}
+
+	configFile := f.ConfigFile
+	if configFile == "" {
+		configFile = defaultDockerConfigFile()
+		if _, err := os.Stat(configFile); os.IsNotExist(err) {
+			return config
+		}
+	}
+	auths, err := readDockerConfig(configFile)
+	if err != nil {
+		panic(errors.WithMessagef(err, "reading registry config file %s failed", configFile))
+	}
+	for host, auth := range auths {
+		config.Auths[RegistryHost(host)] = auth
+	}
+	return config
+}
+
+// Registry stores configuration of docker registries.
+type Registry struct {
+	// Mirrors maps registry hosts to the URLs of their mirrors, tried in order before the registry itself.
+	Mirrors map[string][]string
+
+	// Insecure contains registry hosts accessed using plain HTTP.
+	Insecure map[string]bool
+
+	// Auths maps registry hosts to credentials.
+	Auths map[string]RegistryAuth
+}
+
+// RegistryAuth stores credentials to the registry.
+type RegistryAuth struct {
+	// Username is the name of the user.
+	Username string
+
+	// Password is the password of the user.
+	Password string
+
+	// IdentityToken is the refresh token exchanged for the access token.
+	IdentityToken string
+}
+
+// RegistryHost returns host of the registry, dropping scheme and path, e.g. https://index.docker.io/v1/.
+func RegistryHost(registry string) string {
+	registry = strings.ToLower(registry)
+	if _, rest, ok := strings.Cut(registry, "://"); ok {
+		registry = rest
+	}
+	host, _, _ := strings.Cut(registry, "/")
+	return host
+}
+
+func defaultDockerConfigFile() string {
+	if dir := os.Getenv(dockerConfigEnv); dir != "" {
+		return filepath.Join(dir, "config.json")
+	}
+	return filepath.Join(must.String(os.UserHomeDir()), ".docker", "config.json")
+}
+
+// readDockerConfig reads credentials stored in docker config file. Credential helpers are not supported.
+func readDockerConfig(file string) (map[string]RegistryAuth, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var dockerConfig struct {
+		Auths map[string]struct {
+			Auth          string `json:"auth"`
+			Username      string `json:"username"`
+			Password      string `json:"password"`
+			IdentityToken string `json:"identitytoken"`
+		} `json:"auths"`
+	}
+	if err := json.Unmarshal(data, &dockerConfig); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	auths := make(map[string]RegistryAuth, len(dockerConfig.Auths))
+	for host, a := range dockerConfig.Auths {
+		auth := RegistryAuth{
+			Username:      a.Username,
+			Password:      a.Password,
+			IdentityToken: a.IdentityToken,
+		}
+		if a.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(a.Auth)
+			if err != nil {
+				return nil, errors.Wrapf(err, "decoding credentials of registry %s failed", host)
+			}
+			var ok bool
+			auth.Username, auth.Password, ok = strings.Cut(string(decoded), ":")
+			if !ok {
+				return nil, errors.Errorf("credentials of registry %s are invalid", host)
+			}
+		}
+		auths[host] = auth
+	}
+	return auths, nil
+}
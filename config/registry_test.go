@@ -0,0 +1,74 @@
+package config
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeDockerConfig(t *testing.T, content string) string {
+	t.Helper()
+
+	file := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestReadDockerConfig(t *testing.T) {
+	file := writeDockerConfig(t, `{"auths":{`+
+		`"https://index.docker.io/v1/":{"auth":"`+base64.StdEncoding.EncodeToString([]byte("user:pa:ss"))+`"},`+
+		`"registry.example.com":{"username":"other","password":"secret"},`+
+		`"token.example.com":{"identitytoken":"refresh"}}}`)
+
+	auths, err := readDockerConfig(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]RegistryAuth{
+		"https://index.docker.io/v1/": {Username: "user", Password: "pa:ss"},
+		"registry.example.com":        {Username: "other", Password: "secret"},
+		"token.example.com":           {IdentityToken: "refresh"},
+	}
+	if len(auths) != len(expected) {
+		t.Fatalf("unexpected auths: %v", auths)
+	}
+	for host, auth := range expected {
+		if auths[host] != auth {
+			t.Errorf("unexpected auth of %s: %+v", host, auths[host])
+		}
+	}
+}
+
+func TestReadDockerConfigInvalidAuth(t *testing.T) {
+	for _, auth := range []string{"not base64!", base64.StdEncoding.EncodeToString([]byte("no-colon"))} {
+		file := writeDockerConfig(t, `{"auths":{"registry.example.com":{"auth":"`+auth+`"}}}`)
+		if _, err := readDockerConfig(file); err == nil {
+			t.Errorf("invalid auth '%s' should be rejected", auth)
+		}
+	}
+}
+
+func TestRegistryConfigHosts(t *testing.T) {
+	file := writeDockerConfig(t, `{"auths":{"https://index.docker.io/v1/":{"auth":"`+
+		base64.StdEncoding.EncodeToString([]byte("user:pass"))+`"}}}`)
+
+	config := (&RegistryFactory{
+		ConfigFile: file,
+		Mirrors:    []string{"docker.io=https://mirror.example.com/"},
+		Insecure:   []string{"http://localhost:5000"},
+	}).Config()
+
+	if auth := config.Auths["index.docker.io"]; auth.Username != "user" || auth.Password != "pass" {
+		t.Errorf("unexpected auths: %v", config.Auths)
+	}
+	if mirrors := config.Mirrors["docker.io"]; len(mirrors) != 1 || mirrors[0] != "https://mirror.example.com" {
+		t.Errorf("unexpected mirrors: %v", config.Mirrors)
+	}
+	if !config.Insecure["localhost:5000"] {
+		t.Errorf("unexpected insecure registries: %v", config.Insecure)
+	}
+}
@@ -0,0 +1,122 @@
+package config
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/ridge/must"
+
+	"github.com/outofforest/osman/infra/types"
+)
+
+// RunFactory collects data for run config.
+type RunFactory struct {
+	// Network is the network available to the command.
+	Network string
+
+	// Mounts is the list of host directories mounted into the image, in the form of `source:target[:ro]`.
+	Mounts []string
+
+	// WorkDir is the working directory of the command.
+	WorkDir string
+
+	// Keep keeps the clone of the image after command exits.
+	Keep bool
+
+	// Tags are applied to the kept clone.
+	Tags []string
+}
+
+// Config returns new run config.
+func (f *RunFactory) Config(args Args) Run {
+	config := Run{
+		Network: f.Network,
+		Mounts:  make([]BindMount, 0, len(f.Mounts)),
+		WorkDir: f.WorkDir,
+		Keep:    f.Keep,
+		Tags:    make(types.Tags, 0, len(f.Tags)),
+		Image:   args[0],
+		Command: args[1:],
+	}
+	if config.Network != NetworkHost && config.Network != NetworkNone {
+		panic(errors.Errorf("network '%s' is invalid", config.Network))
+	}
+	if len(config.Command) == 0 {
+		panic(errors.New("command to run is not provided"))
+	}
+	if !filepath.IsAbs(config.WorkDir) {
+		panic(errors.Errorf("working directory '%s' must be absolute", config.WorkDir))
+	}
+	for _, m := range f.Mounts {
+		config.Mounts = append(config.Mounts, parseBindMount(m))
+	}
+	for _, tag := range f.Tags {
+		config.Tags = append(config.Tags, types.Tag(tag))
+	}
+	if len(config.Tags) > 0 && !config.Keep {
+		panic(errors.New("tags might be set only if clone is kept"))
+	}
+	return config
+}
+
+// BindMount defines host directory mounted into the image.
+type BindMount struct {
+	// Source is the path on the host.
+	Source string
+
+	// Target is the path inside the image.
+	Target string
+
+	// Writable makes mount writable.
+	Writable bool
+}
+
+// Run stores configuration of run command.
+type Run struct {
+	// Network is the network available to the command.
+	Network string
+
+	// Mounts is the list of host directories mounted into the image.
+	Mounts []BindMount
+
+	// WorkDir is the working directory of the command.
+	WorkDir string
+
+	// Keep keeps the clone of the image after command exits.
+	Keep bool
+
+	// Tags are applied to the kept clone.
+	Tags types.Tags
+
+	// Image is the build ID or build key of the image.
+	Image string
+
+	// Command is the command to run together with its arguments.
+	Command []string
+}
+
+func parseBindMount(mount string) BindMount {
+	parts := strings.Split(mount, ":")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		panic(errors.Errorf("invalid mount '%s', expected source:target[:ro]", mount))
+	}
+	res := BindMount{
+		Source:   must.String(filepath.Abs(parts[0])),
+		Target:   parts[1],
+		Writable: true,
+	}
+	if !filepath.IsAbs(res.Target) {
+		panic(errors.Errorf("target '%s' of mount must be absolute", res.Target))
+	}
+	if len(parts) == 3 {
+		switch parts[2] {
+		case "ro":
+			res.Writable = false
+		case "rw":
+		default:
+			panic(errors.Errorf("invalid mount option '%s', expected ro or rw", parts[2]))
+		}
+	}
+	return res
+}
@@ -0,0 +1,57 @@
+package config
+
+import (
+	"github.com/pkg/errors"
+)
+
+// ShellFactory collects data for shell config.
+type ShellFactory struct {
+	// CacheDir is the directory where cached files are stored.
+	CacheDir string
+
+	// Network is the network available to the shell.
+	Network string
+
+	// Secrets is the list of secrets in the form of `id=<id>,src=<path>`.
+	Secrets []string
+}
+
+// Config returns new shell config.
+func (f *ShellFactory) Config(args Args) Shell {
+	config := Shell{
+		CacheDir: f.CacheDir,
+		Network:  f.Network,
+		Secrets:  make(map[string]string, len(f.Secrets)),
+		Build:    args[0],
+		Command:  args[1:],
+	}
+	if config.Network != NetworkHost && config.Network != NetworkNone {
+		panic(errors.Errorf("network '%s' is invalid", config.Network))
+	}
+	for _, secret := range f.Secrets {
+		id, src := parseSecret(secret)
+		if _, exists := config.Secrets[id]; exists {
+			panic(errors.Errorf("secret %s is defined more than once", id))
+		}
+		config.Secrets[id] = src
+	}
+	return config
+}
+
+// Shell stores configuration of shell command.
+type Shell struct {
+	// CacheDir is the directory where cached files are stored.
+	CacheDir string
+
+	// Network is the network available to the shell.
+	Network string
+
+	// Secrets maps secret IDs to files containing them.
+	Secrets map[string]string
+
+	// Build is the build ID or build key of the build.
+	Build string
+
+	// Command is the command executed instead of the default shell.
+	Command []string
+}
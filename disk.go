@@ -0,0 +1,358 @@
+package osman
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/outofforest/logger"
+	"github.com/outofforest/osman/config"
+	"github.com/outofforest/osman/infra"
+	"github.com/outofforest/osman/infra/export"
+	"github.com/outofforest/osman/infra/types"
+)
+
+const (
+	diskFormatRaw   = "raw"
+	diskFormatQCOW2 = "qcow2"
+
+	sectorSize = 512
+	mib        = 1024 * 1024
+
+	// partitionsOffset leaves space for partition table at the beginning of the disk.
+	partitionsOffset = mib
+
+	// partitionTableSize is reserved at the end of the disk for the backup partition table.
+	partitionTableSize = mib
+
+	// minESPSize is the minimum size of FAT32 filesystem.
+	minESPSize = 64 * mib
+
+	// rootFSReserve is the free space left on root filesystem.
+	rootFSReserve = 512 * mib
+
+	espPartitionType   = "C12A7328-F81F-11D2-BA4B-00A0C93EC93B"
+	linuxPartitionType = "0FC63DAF-8483-4772-8E79-3D69D8477DE4"
+	espLabel           = "ESP"
+	rootLabel          = "root"
+
+	// efiDir is the directory inside the image containing EFI boot loaders.
+	efiDir = "boot/efi/EFI"
+
+	// grubFont is the font inside the image, copied to the location expected by GRUB config.
+	grubFont    = "usr/share/grub/unicode.pf2"
+	espGRUBFont = "grub2/fonts/font.pf2"
+
+	// espKernelDir is the directory on ESP containing kernel and initramfs.
+	espKernelDir = "/osman"
+)
+
+// NewRawExporter creates new exporter producing raw disk image of the build.
+func NewRawExporter() export.Exporter {
+	return &diskExporter{format: diskFormatRaw}
+}
+
+// NewQCOW2Exporter creates new exporter producing qcow2 disk image of the build.
+func NewQCOW2Exporter() export.Exporter {
+	return &diskExporter{format: diskFormatQCOW2}
+}
+
+type diskExporter struct {
+	format string
+}
+
+// Export creates partitioned disk image. First partition is the ESP containing EFI boot loaders of the image,
+// its kernel, initramfs and GRUB config. The second one contains root filesystem.
+func (e *diskExporter) Export(ctx context.Context, image export.Image, output string) (retErr error) {
+	build := image.Build
+	if len(build.Boots) == 0 {
+		return errors.Errorf(
+			"image %s can't be exported as disk because it was built without specifying BOOT option(s)",
+			build.BuildID,
+		)
+	}
+	if len(image.Layers) > 1 {
+		return errors.Errorf("%s format doesn't support layers", e.format)
+	}
+	if output == config.ExportStdout {
+		return errors.Errorf("%s format can't be written to standard output", e.format)
+	}
+
+	workDir, err := os.MkdirTemp("", "osman-disk-*")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(workDir); err != nil && retErr == nil {
+			retErr = errors.WithStack(err)
+		}
+	}()
+
+	rootUUID := uuid.New()
+	espDir := filepath.Join(workDir, "esp")
+	if err := prepareESP(espDir, image.Root(), build, rootUUID); err != nil {
+		return err
+	}
+
+	espSize, err := dirSize(espDir)
+	if err != nil {
+		return err
+	}
+	espSize = max(alignSize(espSize*5/4), minESPSize)
+	rootSize, err := dirSize(image.Root())
+	if err != nil {
+		return err
+	}
+	rootSize = alignSize(rootSize*5/4 + rootFSReserve)
+
+	disk := output
+	if e.format != diskFormatRaw {
+		disk = filepath.Join(workDir, "disk."+diskFormatRaw)
+	}
+
+	log := logger.Get(ctx)
+	log.Info("Creating disk image", zap.String("buildID", string(build.BuildID)),
+		zap.Int64("espSize", espSize), zap.Int64("rootSize", rootSize))
+	if err := createDisk(ctx, disk, espSize, rootSize, rootUUID); err != nil {
+		return err
+	}
+	if err := createESP(ctx, disk, partitionsOffset, espSize, espDir); err != nil {
+		return err
+	}
+	if err := createRootFS(ctx, disk, partitionsOffset+espSize, rootSize, image.Root()); err != nil {
+		return err
+	}
+
+	if disk == output {
+		return nil
+	}
+	log.Info("Converting disk image", zap.String("format", e.format))
+	return runTool(ctx, nil, "qemu-img", "convert", "-f", diskFormatRaw, "-O", e.format, disk, output)
+}
+
+// prepareESP stores files of ESP partition in the directory. Symlinks are resolved inside the image,
+// as they are resolved by the boot loader.
+func prepareESP(espDir, root string, build types.BuildInfo, rootUUID uuid.UUID) error {
+	efiPath, err := infra.ResolveInRoot(root, efiDir)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(efiPath); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return errors.Errorf("image %s doesn't contain EFI boot loader in /%s", build.BuildID, efiDir)
+		}
+		return errors.WithStack(err)
+	}
+	if err := copyDir(filepath.Join(espDir, "EFI"), root, efiPath); err != nil {
+		return err
+	}
+
+	kernelDir := filepath.Join(espDir, espKernelDir)
+	if err := os.MkdirAll(kernelDir, 0o755); err != nil {
+		return errors.WithStack(err)
+	}
+	for _, file := range []string{"vmlinuz", "initramfs.img"} {
+		path, err := infra.ResolveInRoot(root, filepath.Join("/boot", file))
+		if err != nil {
+			return err
+		}
+		if err := copyFile(filepath.Join(kernelDir, file), path, 0o644); err != nil {
+			return err
+		}
+	}
+
+	fontPath, err := infra.ResolveInRoot(root, grubFont)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(fontPath); err == nil {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(espDir, espGRUBFont)), 0o755); err != nil {
+			return errors.WithStack(err)
+		}
+		if err := copyFile(filepath.Join(espDir, espGRUBFont), fontPath, 0o644); err != nil {
+			return err
+		}
+	}
+
+	grubConfig, err := renderGRUB(grubConfig{
+		Root: "(hd0,gpt1)",
+		Builds: []grubBuild{
+			{
+				Name:      grubName(build),
+				KernelDir: espKernelDir,
+				Params:    append([]string{"root=PARTUUID=" + rootUUID.String(), "rw"}, build.Params...),
+				Boots:     build.Boots,
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Each boot loader reads config from its own directory.
+	vendorDirs, err := os.ReadDir(filepath.Join(espDir, "EFI"))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	for _, vendorDir := range vendorDirs {
+		if !vendorDir.IsDir() {
+			continue
+		}
+		dir := filepath.Join(espDir, "EFI", vendorDir.Name())
+		hasBinary, err := containsEFIBinary(dir)
+		if err != nil {
+			return err
+		}
+		if !hasBinary {
+			continue
+		}
+		if err := os.WriteFile(filepath.Join(dir, "grub.cfg"), grubConfig, 0o644); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
+// createDisk creates disk file with GPT partition table.
+func createDisk(ctx context.Context, disk string, espSize, rootSize int64, rootUUID uuid.UUID) error {
+	f, err := os.OpenFile(disk, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer f.Close()
+
+	if err := f.Truncate(partitionsOffset + espSize + rootSize + partitionTableSize); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := f.Close(); err != nil {
+		return errors.WithStack(err)
+	}
+
+	layout := fmt.Sprintf(`label: gpt
+start=%d, size=%d, type=%s, name="%s"
+start=%d, size=%d, type=%s, uuid=%s, name="%s"
+`,
+		partitionsOffset/sectorSize, espSize/sectorSize, espPartitionType, espLabel,
+		(partitionsOffset+espSize)/sectorSize, rootSize/sectorSize, linuxPartitionType, rootUUID, rootLabel,
+	)
+	return runTool(ctx, strings.NewReader(layout), "sfdisk", "--quiet", disk)
+}
+
+// createESP creates FAT32 filesystem inside the partition and copies files to it.
+func createESP(ctx context.Context, disk string, offset, size int64, espDir string) error {
+	if err := runTool(ctx, nil, "mkfs.vfat", "-F", "32", "-n", espLabel,
+		fmt.Sprintf("--offset=%d", offset/sectorSize), disk, fmt.Sprintf("%d", size/1024)); err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(espDir)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	args := []string{"-s", "-p", "-i", fmt.Sprintf("%s@@%d", disk, offset)}
+	for _, entry := range entries {
+		args = append(args, filepath.Join(espDir, entry.Name()))
+	}
+	return runTool(ctx, nil, "mcopy", append(args, "::/")...)
+}
+
+// createRootFS creates ext4 filesystem inside the partition populated with the content of root directory.
+func createRootFS(ctx context.Context, disk string, offset, size int64, root string) error {
+	return runTool(ctx, nil, "mkfs.ext4", "-q", "-L", rootLabel, "-E", fmt.Sprintf("offset=%d", offset),
+		"-d", root, disk, fmt.Sprintf("%dk", size/1024))
+}
+
+// runTool runs external tool. Its output is returned in the error if tool fails.
+func runTool(ctx context.Context, stdin io.Reader, name string, args ...string) error {
+	output := &bytes.Buffer{}
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdin = stdin
+	cmd.Stdout = output
+	cmd.Stderr = output
+	if err := cmd.Run(); err != nil {
+		return errors.Wrapf(err, "%s failed: %s", name, strings.TrimSpace(output.String()))
+	}
+	return nil
+}
+
+func containsEFIBinary(dir string) (bool, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(strings.ToLower(entry.Name()), ".efi") {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// copyDir copies regular files and directories found in the directory inside root. FAT doesn't support symlinks,
+// so files they point to are copied, resolved inside root. Other entries are skipped because FAT doesn't support them.
+func copyDir(dst, root, src string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		target := filepath.Join(dst, strings.TrimPrefix(path, src))
+		switch {
+		case d.IsDir():
+			return errors.WithStack(os.MkdirAll(target, 0o755))
+		case d.Type().IsRegular():
+			return copyFile(target, path, 0o644)
+		case d.Type()&fs.ModeSymlink != 0:
+			linkPath := strings.TrimPrefix(path, root)
+			resolved, err := infra.ResolveInRoot(root, linkPath)
+			if err != nil {
+				return err
+			}
+			info, err := os.Stat(resolved)
+			switch {
+			case errors.Is(err, os.ErrNotExist):
+				return errors.Errorf("symlink %s points to file which doesn't exist", linkPath)
+			case err != nil:
+				return errors.WithStack(err)
+			case !info.Mode().IsRegular():
+				return errors.Errorf("symlink %s doesn't point to regular file", linkPath)
+			}
+			return copyFile(target, resolved, 0o644)
+		default:
+			return nil
+		}
+	})
+}
+
+// dirSize returns the space occupied by files in the directory, rounded up to the filesystem blocks.
+func dirSize(dir string) (int64, error) {
+	const blockSize = 4096
+
+	var size int64
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		info, err := d.Info()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		size += (info.Size()/blockSize + 1) * blockSize
+		return nil
+	})
+	return size, err
+}
+
+// alignSize rounds size up to MiB.
+func alignSize(size int64) int64 {
+	return (size + mib - 1) / mib * mib
+}
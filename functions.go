@@ -4,15 +4,21 @@ import (
 	"context"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 
 	"github.com/pkg/errors"
 	"github.com/ridge/must"
+	"go.uber.org/zap"
 	"libvirt.org/go/libvirtxml"
 
 	"github.com/outofforest/logger"
 	"github.com/outofforest/osman/config"
 	"github.com/outofforest/osman/infra"
+	"github.com/outofforest/osman/infra/buildlog"
+	"github.com/outofforest/osman/infra/cache"
 	"github.com/outofforest/osman/infra/description"
+	"github.com/outofforest/osman/infra/export"
 	"github.com/outofforest/osman/infra/storage"
 	"github.com/outofforest/osman/infra/types"
 )
@@ -22,12 +28,41 @@ func Build(
 	ctx context.Context,
 	build config.Build,
 	s storage.Driver,
+	repo *infra.Repository,
 	builder *infra.Builder,
 ) ([]types.BuildInfo, error) {
+	if err := repo.LoadSpecPath(build.SpecPath); err != nil {
+		return nil, err
+	}
+
 	builds := make([]types.BuildInfo, 0, len(build.SpecFiles))
 	for i, specFile := range build.SpecFiles {
-		must.OK(os.Chdir(filepath.Dir(specFile)))
-		buildID, err := builder.BuildFromFile(ctx, build.CacheDir, specFile, build.Names[i], build.Tags...)
+		var name string
+		if i < len(build.Names) {
+			name = build.Names[i]
+		}
+		tags := build.Tags
+
+		var buildID types.BuildID
+		var err error
+		if img := registeredImage(specFile, repo); img != nil {
+			if name == "" {
+				name = img.Name()
+			}
+			if len(tags) == 0 {
+				tags = img.Tags()
+			}
+			buildID, err = builder.Build(ctx, build.CacheDir, description.Describe(name, tags, img.Commands()...))
+		} else {
+			if name == "" {
+				name = strings.TrimSuffix(filepath.Base(specFile), ".spec")
+			}
+			if len(tags) == 0 {
+				tags = types.Tags{description.DefaultTag}
+			}
+			must.OK(os.Chdir(filepath.Dir(specFile)))
+			buildID, err = builder.BuildFromFile(ctx, build.CacheDir, specFile, name, tags...)
+		}
 		if err != nil {
 			return nil, err
 		}
@@ -40,6 +75,21 @@ func Build(
 	return builds, nil
 }
 
+// registeredImage returns image registered in repository if argument is not a file but a build key of such image.
+func registeredImage(arg string, repo *infra.Repository) *description.Descriptor {
+	if _, err := os.Stat(arg); err == nil {
+		return nil
+	}
+	buildKey, err := types.ParseBuildKey(arg)
+	if err != nil {
+		return nil
+	}
+	if buildKey.Tag == "" {
+		buildKey.Tag = description.DefaultTag
+	}
+	return repo.Retrieve(buildKey)
+}
+
 // Mount mounts image.
 func Mount(
 	ctx context.Context,
@@ -175,7 +225,7 @@ func Start(
 
 // Stop stops VMs.
 func Stop(ctx context.Context, filtering config.Filter, stop config.Stop, s storage.Driver) ([]Result, error) {
-	if !stop.All && len(filtering.BuildIDs) == 0 && len(filtering.BuildKeys) == 0 {
+	if !stop.All && !filtering.HasCriteria() {
 		return nil, errors.New("neither filters are provided nor --all is set")
 	}
 
@@ -228,7 +278,8 @@ func List(ctx context.Context, filtering config.Filter, s storage.Driver) ([]typ
 			return nil, err
 		}
 
-		if !listBuild(info, buildTypes, buildIDs, buildKeys, filtering.Untagged) {
+		if !listBuild(info, buildTypes, buildIDs, buildKeys, filtering.Untagged) ||
+			!matchLabels(info.Labels, filtering.Labels, filtering.LabelKeys) {
 			continue
 		}
 		list = append(list, info)
@@ -250,7 +301,7 @@ func Drop(
 	drop config.Drop,
 	s storage.Driver,
 ) ([]Result, error) {
-	if !drop.All && len(filtering.BuildIDs) == 0 && len(filtering.BuildKeys) == 0 {
+	if !drop.All && !filtering.HasCriteria() {
 		return nil, errors.New("neither filters are provided nor --all is set")
 	}
 
@@ -332,6 +383,9 @@ func Drop(
 		if res.Result == nil {
 			res.Result = s.Drop(ctx, buildID)
 		}
+		if res.Result == nil {
+			res.Result = buildlog.Remove(drop.CacheDir, buildID)
+		}
 		if buildID.Type() == types.BuildTypeBoot && res.Result == nil {
 			genGRUB = true
 			res.Result = cleanKernel(buildID, "boot-")
@@ -351,7 +405,7 @@ func Drop(
 
 // Tag removes and add tags to the build.
 func Tag(ctx context.Context, filtering config.Filter, tag config.Tag, s storage.Driver) ([]types.BuildInfo, error) {
-	if !tag.All && len(filtering.BuildIDs) == 0 && len(filtering.BuildKeys) == 0 {
+	if !tag.All && !filtering.HasCriteria() {
 		return nil, errors.New("neither filters are provided nor All is set")
 	}
 
@@ -415,6 +469,21 @@ func listBuild(
 	return buildIDs == nil && buildKeys == nil
 }
 
+// matchLabels returns true if build has all the labels with the required values and all the label keys.
+func matchLabels(buildLabels, labels types.Labels, labelKeys []string) bool {
+	for key, value := range labels {
+		if v, exists := buildLabels[key]; !exists || v != value {
+			return false
+		}
+	}
+	for _, key := range labelKeys {
+		if _, exists := buildLabels[key]; !exists {
+			return false
+		}
+	}
+	return true
+}
+
 func cloneForMount(
 	ctx context.Context,
 	image types.BuildInfo,
@@ -437,6 +506,7 @@ func cloneForMount(
 		BuildID: buildID,
 		BasedOn: image.BuildID,
 		Params:  image.Params,
+		Labels:  image.Labels,
 	}
 	if mount.Type == types.BuildTypeBoot {
 		manifest.Boots = image.Boots
@@ -474,3 +544,282 @@ func cloneForMount(
 
 	return s.Info(ctx, buildID)
 }
+
+// CacheList lists persistent caches.
+func CacheList(cacheConfig config.Cache) ([]cache.Info, error) {
+	return cache.New(cacheConfig.CacheDir).List()
+}
+
+// CachePrune deletes persistent caches.
+func CachePrune(cacheConfig config.Cache) ([]cache.PruneResult, error) {
+	if !cacheConfig.All && len(cacheConfig.IDs) == 0 {
+		return nil, errors.New("neither cache IDs are provided nor --all is set")
+	}
+	return cache.New(cacheConfig.CacheDir).Prune(cacheConfig.IDs...)
+}
+
+// Logs reads logs of the build.
+func Logs(ctx context.Context, logs config.Logs, s storage.Driver, fn func(record buildlog.Record) error) error {
+	buildID, err := resolveBuildID(ctx, logs.Build, s)
+	if err != nil {
+		return err
+	}
+	return buildlog.Read(ctx, logs.CacheDir, buildID, logs.Step, logs.Follow, fn)
+}
+
+// Shell opens interactive shell inside the temporary clone of the build.
+func Shell(ctx context.Context, shell config.Shell, s storage.Driver) (retErr error) {
+	srcBuildID, err := resolveBuildID(ctx, shell.Build, s)
+	if err != nil {
+		return err
+	}
+	if !srcBuildID.Type().Properties().Cloneable {
+		return errors.Errorf("build %s is not cloneable", srcBuildID)
+	}
+	image, err := s.Info(ctx, srcBuildID)
+	if err != nil {
+		return err
+	}
+
+	buildID := types.NewBuildID(types.BuildTypeImage)
+	_, path, err := s.Clone(ctx, image.BuildID, image.Name, buildID)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := s.Drop(ctx, buildID); err != nil && retErr == nil {
+			retErr = err
+		}
+	}()
+
+	return infra.Shell(ctx, path, shell)
+}
+
+// Run runs command inside the temporary clone of the image and returns its exit code.
+func Run(ctx context.Context, run config.Run, s storage.Driver) (retExitCode int, retErr error) {
+	srcBuildID, err := resolveBuildID(ctx, run.Image, s)
+	if err != nil {
+		return 0, err
+	}
+	if !srcBuildID.Type().Properties().Cloneable {
+		return 0, errors.Errorf("build %s is not cloneable", srcBuildID)
+	}
+	image, err := s.Info(ctx, srcBuildID)
+	if err != nil {
+		return 0, err
+	}
+
+	buildID := types.NewBuildID(types.BuildTypeImage)
+	finalizeFn, path, err := s.Clone(ctx, image.BuildID, image.Name, buildID)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if !run.Keep || retErr != nil {
+			if err := s.Drop(ctx, buildID); err != nil && retErr == nil {
+				retErr = err
+			}
+		}
+	}()
+
+	exitCode, err := infra.Run(ctx, path, run)
+	if err != nil {
+		return 0, err
+	}
+	if !run.Keep {
+		return exitCode, nil
+	}
+
+	if err := s.StoreManifest(ctx, types.ImageManifest{
+		BuildID: buildID,
+		BasedOn: image.BuildID,
+		Params:  image.Params,
+		Boots:   image.Boots,
+		Labels:  image.Labels,
+	}); err != nil {
+		return 0, err
+	}
+	tags := run.Tags
+	if len(tags) == 0 {
+		tags = types.Tags{types.Tag(types.RandomString(5))}
+	}
+	for _, tag := range tags {
+		if err := s.Tag(ctx, buildID, tag); err != nil {
+			return 0, err
+		}
+	}
+	if err := finalizeFn(); err != nil {
+		return 0, err
+	}
+
+	logger.Get(ctx).Info("Clone kept", zap.String("buildID", string(buildID)), zap.String("name", image.Name),
+		zap.Stringer("tags", tags))
+	return exitCode, nil
+}
+
+// Export exports the build using the exporter selected by format. Temporary clones of the build, and
+// of its ancestors if layers were requested, are used as the source of exported files.
+func Export(
+	ctx context.Context,
+	exportConfig config.Export,
+	s storage.Driver,
+	exporter export.Exporter,
+) (retInfo types.BuildInfo, retErr error) {
+	buildID, err := resolveBuildID(ctx, exportConfig.Build, s)
+	if err != nil {
+		return types.BuildInfo{}, err
+	}
+	if !buildID.Type().Properties().Cloneable {
+		return types.BuildInfo{}, errors.Errorf("build %s is not cloneable", buildID)
+	}
+	info, err := s.Info(ctx, buildID)
+	if err != nil {
+		return types.BuildInfo{}, err
+	}
+
+	lineage := []types.BuildInfo{info}
+	if exportConfig.Layers {
+		for basedOn := info.BasedOn; basedOn != ""; {
+			ancestor, err := s.Info(ctx, basedOn)
+			if err != nil {
+				return types.BuildInfo{}, err
+			}
+			lineage = append([]types.BuildInfo{ancestor}, lineage...)
+			basedOn = ancestor.BasedOn
+		}
+	}
+
+	image := export.Image{
+		Build:  info,
+		Layers: make([]export.Layer, 0, len(lineage)),
+	}
+	for _, build := range lineage {
+		cloneID := types.NewBuildID(types.BuildTypeImage)
+		_, path, err := s.Clone(ctx, build.BuildID, build.Name, cloneID)
+		if err != nil {
+			return types.BuildInfo{}, err
+		}
+		defer func() {
+			if err := s.Drop(ctx, cloneID); err != nil && retErr == nil {
+				retErr = err
+			}
+		}()
+		image.Layers = append(image.Layers, export.Layer{
+			Build: build,
+			Root:  path,
+		})
+	}
+
+	if err := exporter.Export(ctx, image, exportConfig.Output); err != nil {
+		return types.BuildInfo{}, err
+	}
+	return info, nil
+}
+
+// Inspection contains information about the build together with its ancestors and children.
+type Inspection struct {
+	// Build is the inspected build.
+	Build types.BuildInfo
+
+	// Lineage is the list of ancestors, starting from the parent of the build.
+	Lineage []types.BuildInfo
+
+	// Children is the list of builds based directly on the inspected one.
+	Children []types.BuildInfo
+}
+
+// Inspect returns information about the build.
+func Inspect(ctx context.Context, inspect config.Inspect, s storage.Driver) (Inspection, error) {
+	buildID, err := resolveBuildID(ctx, inspect.Build, s)
+	if err != nil {
+		return Inspection{}, err
+	}
+
+	var inspection Inspection
+	inspection.Build, err = s.Info(ctx, buildID)
+	if err != nil {
+		return Inspection{}, err
+	}
+
+	for basedOn := inspection.Build.BasedOn; basedOn != ""; {
+		info, err := s.Info(ctx, basedOn)
+		if err != nil {
+			return Inspection{}, err
+		}
+		inspection.Lineage = append(inspection.Lineage, info)
+		basedOn = info.BasedOn
+	}
+
+	builds, err := s.Builds(ctx)
+	if err != nil {
+		return Inspection{}, err
+	}
+	for _, childID := range builds {
+		info, err := s.Info(ctx, childID)
+		if err != nil {
+			return Inspection{}, err
+		}
+		if info.BasedOn == buildID {
+			inspection.Children = append(inspection.Children, info)
+		}
+	}
+	sort.Slice(inspection.Children, func(i, j int) bool {
+		return inspection.Children[i].CreatedAt.Before(inspection.Children[j].CreatedAt)
+	})
+	return inspection, nil
+}
+
+// StaleBuild is the build which is out of date.
+type StaleBuild struct {
+	BuildID types.BuildID
+	Name    string
+	Tags    types.Tags
+	Reason  string
+}
+
+// Status returns tagged builds which are out of date, because their spec file or parent changed.
+func Status(ctx context.Context, filtering config.Filter, s storage.Driver) ([]StaleBuild, error) {
+	builds, err := List(ctx, filtering, s)
+	if err != nil {
+		return nil, err
+	}
+
+	checker := infra.NewStalenessChecker(s)
+	var stale []StaleBuild
+	for _, build := range builds {
+		// Untagged builds have been replaced by newer ones, there is no point in reporting them.
+		if len(build.Tags) == 0 {
+			continue
+		}
+		reason, err := checker.Check(ctx, build.BuildID)
+		if err != nil {
+			return nil, err
+		}
+		if reason != "" {
+			stale = append(stale, StaleBuild{
+				BuildID: build.BuildID,
+				Name:    build.Name,
+				Tags:    build.Tags,
+				Reason:  reason,
+			})
+		}
+	}
+	return stale, nil
+}
+
+// resolveBuildID returns ID of the build referenced either by build ID or build key.
+func resolveBuildID(ctx context.Context, build string, s storage.Driver) (types.BuildID, error) {
+	buildID, err := types.ParseBuildID(build)
+	if err == nil {
+		return buildID, nil
+	}
+
+	buildKey, err := types.ParseBuildKey(build)
+	if err != nil {
+		return "", errors.Errorf("argument '%s' is neither valid build ID nor build key", build)
+	}
+	if buildKey.Tag == "" {
+		buildKey.Tag = description.DefaultTag
+	}
+	return s.BuildID(ctx, buildKey)
+}
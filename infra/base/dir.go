@@ -0,0 +1,81 @@
+package base
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+
+	"github.com/outofforest/isolator/wire"
+	"github.com/outofforest/osman/infra/description"
+	"github.com/outofforest/osman/infra/runner"
+	"github.com/outofforest/osman/infra/types"
+)
+
+// NewDirInitializer creates new initializer copying root filesystem from host directory.
+func NewDirInitializer() Initializer {
+	return &dirInitializer{}
+}
+
+type dirInitializer struct {
+}
+
+// BuildKey returns the key image is stored under, derived from the absolute path of the directory.
+// Content of the directory is not tracked, so changes to it are taken into account only when image is rebuilt.
+func (i *dirInitializer) BuildKey(location, digest string) (types.BuildKey, error) {
+	if digest != "" {
+		return types.BuildKey{}, errPinNotSupported
+	}
+	dir, err := absDir(location)
+	if err != nil {
+		return types.BuildKey{}, err
+	}
+	return newBuildKey("dir-"+dir, description.DefaultTag)
+}
+
+// Init copies content of the host directory inside directory preserving owners, modes and extended attributes.
+func (i *dirInitializer) Init(ctx context.Context, cacheDir, dir, location, digest string) (string, error) {
+	srcDir, err := absDir(location)
+	if err != nil {
+		return "", err
+	}
+	err = execute(ctx, dir, wire.Config{
+		Mounts: []wire.Mount{
+			{
+				Host:      srcDir,
+				Namespace: baseMountpoint,
+			},
+		},
+	}, runner.Copy{
+		Root:              baseMountpoint,
+		Sources:           []string{baseMountpoint},
+		Dest:              "/",
+		PreserveOwnership: true,
+	})
+	return "", errors.WithMessagef(err, "copying root filesystem from %s failed", srcDir)
+}
+
+// absDir returns absolute path of the existing directory. Relative paths are relative to the spec file directory.
+func absDir(location string) (string, error) {
+	if location == "" {
+		return "", errors.New("directory is not specified")
+	}
+	dir, err := filepath.Abs(location)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	if !info.IsDir() {
+		return "", errors.Errorf("%s is not a directory", dir)
+	}
+	return dir, nil
+}
+
+// Digest returns empty digest because content of the directory is not verified.
+func (i *dirInitializer) Digest(ctx context.Context, location string) (string, error) {
+	return "", nil
+}
@@ -2,69 +2,191 @@ package base
 
 import (
 	"context"
+	"io"
+	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/pkg/errors"
 
-	"github.com/outofforest/isolator"
 	"github.com/outofforest/isolator/wire"
+	"github.com/outofforest/osman/config"
+	"github.com/outofforest/osman/infra/description"
 	"github.com/outofforest/osman/infra/types"
 )
 
+const (
+	dockerCacheDir        = "docker-images"
+	dockerCacheMountpoint = "/.docker-cache"
+)
+
 // NewDockerInitializer creates new initializer getting base images from docker registry.
-func NewDockerInitializer() Initializer {
-	return &dockerInitializer{}
+// Images from docker hub are inflated by the executor, unless mirrors, credentials or plain HTTP
+// are configured for it, like for other registries, then they are fetched by the registry client.
+func NewDockerInitializer(config config.Registry) Initializer {
+	return &dockerInitializer{
+		client: newRegistryClient(&http.Client{}, config),
+	}
 }
 
 type dockerInitializer struct {
+	client *registryClient
+}
+
+// BuildKey returns the key image is stored under. Slashes in the image name are replaced by underscores.
+// Images pinned by digest are stored under the digest used as a tag.
+func (f *dockerInitializer) BuildKey(location, digest string) (types.BuildKey, error) {
+	name, tag, err := parseDockerImage(location)
+	if err != nil {
+		return types.BuildKey{}, err
+	}
+	if digest != "" {
+		tag = types.Tag(digest)
+	}
+	return newBuildKey(name, tag)
+}
+
+// Init fetches image from docker registry and integrates it inside directory. Digest of the manifest the tag
+// points to is returned.
+func (f *dockerInitializer) Init(ctx context.Context, cacheDir, dir, location, digest string) (string, error) {
+	name, tag, err := parseDockerImage(location)
+	if err != nil {
+		return "", err
+	}
+	reference := string(tag)
+	if digest != "" {
+		reference = digest
+	}
+
+	image := newDockerImage(name)
+	if f.inflatedByExecutor(image) {
+		return f.inflate(ctx, cacheDir, dir, image, reference)
+	}
+
+	desc, data, err := f.client.manifest(ctx, image, reference)
+	if err != nil {
+		return "", err
+	}
+
+	manifest, err := platformManifest(desc, func(d ociDescriptor) ([]byte, error) {
+		if d.Digest == desc.Digest {
+			return data, nil
+		}
+		_, data, err := f.client.manifest(ctx, image, d.Digest)
+		return data, err
+	})
+	if err != nil {
+		return "", errors.WithMessagef(err, "selecting manifest of image %s failed", image)
+	}
+
+	if err := unpackLayers(ctx, cacheDir, dir, manifest.Layers, func(d ociDescriptor) (io.ReadCloser, error) {
+		return f.client.blob(ctx, image, d.Digest)
+	}); err != nil {
+		return "", err
+	}
+	return desc.Digest, nil
 }
 
-// Init fetches image from docker registry and integrates it inside directory.
-func (f *dockerInitializer) Init(ctx context.Context, cacheDir, dir string, buildKey types.BuildKey) (retErr error) {
-	cacheDir = filepath.Join(cacheDir, "docker-images")
+// inflatedByExecutor returns true if image is taken from docker hub with no configuration applied to it,
+// so it is inflated by the executor.
+func (f *dockerInitializer) inflatedByExecutor(image dockerImage) bool {
+	return image.Registry == dockerHubRegistry && !f.client.configured(image.Registry)
+}
+
+// inflate fetches image from docker hub using the executor. Pinned digest is passed as the tag, so the executor
+// fetches the manifest by digest. Digest is computed from the manifest cached by the executor.
+func (f *dockerInitializer) inflate(ctx context.Context, cacheDir, dir string, image dockerImage,
+	reference string,
+) (string, error) {
+	cacheDir = filepath.Join(cacheDir, dockerCacheDir)
 	if err := os.MkdirAll(cacheDir, 0o700); err != nil {
-		return errors.WithStack(err)
+		return "", errors.WithStack(err)
 	}
 
-	return isolator.Run(ctx, isolator.Config{
-		Dir: dir,
-		Types: []interface{}{
-			wire.Result{},
-		},
-		Executor: wire.Config{
-			UseHostNetwork: true,
-			Mounts: []wire.Mount{
-				{
-					Host:      cacheDir,
-					Namespace: "/.docker-cache",
-					Writable:  true,
-				},
+	var digest string
+	if _, _, err := newDigestHasher(reference); err == nil {
+		digest = reference
+	}
+
+	// Executor never refetches the manifest it cached, so manifest cached for the tag is removed to get the one
+	// the tag points to now. Manifests cached for digests never change.
+	manifestPath := filepath.Join(cacheDir, strings.ReplaceAll(image.Repository, "/", ":")+":"+reference+
+		":manifest.json")
+	if digest == "" {
+		if err := os.Remove(manifestPath); err != nil && !os.IsNotExist(err) {
+			return "", errors.WithStack(err)
+		}
+	}
+
+	if err := execute(ctx, dir, wire.Config{
+		UseHostNetwork: true,
+		Mounts: []wire.Mount{
+			{
+				Host:      cacheDir,
+				Namespace: dockerCacheMountpoint,
+				Writable:  true,
 			},
 		},
-	}, func(ctx context.Context, incoming <-chan interface{}, outgoing chan<- interface{}) error {
-		select {
-		case <-ctx.Done():
-			return errors.WithStack(ctx.Err())
-		case outgoing <- wire.InflateDockerImage{
-			CacheDir: "/.docker-cache",
-			Image:    buildKey.Name,
-			Tag:      string(buildKey.Tag),
-		}:
-		}
+	}, wire.InflateDockerImage{
+		CacheDir: dockerCacheMountpoint,
+		Image:    image.Repository,
+		Tag:      reference,
+	}); err != nil {
+		return "", err
+	}
 
-		for content := range incoming {
-			switch m := content.(type) {
-			case wire.Result:
-				if m.Error != "" {
-					return errors.New(m.Error)
-				}
-				return nil
-			default:
-				return errors.New("unexpected message received")
-			}
-		}
+	manifestFile, err := os.Open(manifestPath)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	defer manifestFile.Close()
 
-		return errors.WithStack(ctx.Err())
-	})
+	data, err := readManifest(manifestFile, digest)
+	if err != nil {
+		return "", err
+	}
+	if digest != "" {
+		return digest, nil
+	}
+	return computeDigest(data), nil
+}
+
+// Digest returns digest of the manifest the tag currently points to. For images inflated by the executor
+// it is the digest of the manifest built for the platform of the host, the same as the one returned by Init.
+func (f *dockerInitializer) Digest(ctx context.Context, location string) (string, error) {
+	name, tag, err := parseDockerImage(location)
+	if err != nil {
+		return "", err
+	}
+	image := newDockerImage(name)
+	if f.inflatedByExecutor(image) {
+		return f.client.platformDigest(ctx, image, string(tag))
+	}
+	desc, _, err := f.client.manifest(ctx, image, string(tag))
+	if err != nil {
+		return "", err
+	}
+	return desc.Digest, nil
+}
+
+// parseDockerImage splits location into image name and tag, e.g. quay.io/fedora/fedora:40.
+// Image might be referenced by digest too, e.g. fedora@sha256:<hex>, then digest is returned as a tag.
+func parseDockerImage(location string) (string, types.Tag, error) {
+	name, tag := location, description.DefaultTag
+	if i := strings.LastIndex(location, "@"); i >= 0 {
+		name, tag = location[:i], types.Tag(location[i+1:])
+		if _, _, err := newDigestHasher(string(tag)); err != nil {
+			return "", "", err
+		}
+	} else if i := strings.LastIndex(location, ":"); i > strings.LastIndex(location, "/") {
+		name, tag = location[:i], types.Tag(location[i+1:])
+	}
+	if name == "" {
+		return "", "", errors.Errorf("docker image is not specified in '%s'", location)
+	}
+	if !tag.IsValid() {
+		return "", "", errors.Errorf("tag '%s' of docker image %s is invalid", tag, name)
+	}
+	return name, tag, nil
 }
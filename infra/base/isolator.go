@@ -0,0 +1,61 @@
+package base
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+
+	"github.com/outofforest/isolator"
+	"github.com/outofforest/isolator/wire"
+)
+
+// execute sends messages one by one to the executor running inside directory and waits for the result of each.
+// Mountpoints are removed afterwards, so they don't land in the image.
+func execute(ctx context.Context, dir string, config wire.Config, messages ...interface{}) (retErr error) {
+	defer func() {
+		for _, m := range config.Mounts {
+			if err := os.Remove(filepath.Join(dir, m.Namespace)); err != nil && !os.IsNotExist(err) && retErr == nil {
+				retErr = errors.WithStack(err)
+			}
+		}
+	}()
+
+	return isolator.Run(ctx, isolator.Config{
+		Dir: dir,
+		Types: []interface{}{
+			wire.Result{},
+		},
+		Executor: config,
+	}, func(ctx context.Context, incoming <-chan interface{}, outgoing chan<- interface{}) error {
+		for _, msg := range messages {
+			select {
+			case <-ctx.Done():
+				return errors.WithStack(ctx.Err())
+			case outgoing <- msg:
+			}
+
+			if err := result(ctx, incoming); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func result(ctx context.Context, incoming <-chan interface{}) error {
+	for content := range incoming {
+		switch m := content.(type) {
+		case wire.Result:
+			if m.Error != "" {
+				return errors.New(m.Error)
+			}
+			return nil
+		default:
+			return errors.New("unexpected message received")
+		}
+	}
+
+	return errors.WithStack(ctx.Err())
+}
@@ -0,0 +1,266 @@
+package base
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/outofforest/isolator/wire"
+	"github.com/outofforest/osman/infra/runner"
+)
+
+const (
+	blobsCacheDir   = "oci-blobs"
+	blobsMountpoint = "/.oci-blobs"
+
+	mediaTypeOCIIndex        = "application/vnd.oci.image.index.v1+json"
+	mediaTypeOCIManifest     = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeDockerList      = "application/vnd.docker.distribution.manifest.list.v2+json"
+	mediaTypeDockerManifest  = "application/vnd.docker.distribution.manifest.v2+json"
+	mediaTypeOCILayer        = "application/vnd.oci.image.layer.v1.tar"
+	mediaTypeOCILayerGzip    = "application/vnd.oci.image.layer.v1.tar+gzip"
+	mediaTypeOCILayerZstd    = "application/vnd.oci.image.layer.v1.tar+zstd"
+	mediaTypeDockerLayer     = "application/vnd.docker.image.rootfs.diff.tar"
+	mediaTypeDockerLayerGzip = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+
+	maxManifestSize int64 = 4 * 1024 * 1024
+)
+
+var (
+	manifestMediaTypes = []string{
+		mediaTypeOCIIndex,
+		mediaTypeOCIManifest,
+		mediaTypeDockerList,
+		mediaTypeDockerManifest,
+	}
+	layerMediaTypes = map[string]bool{
+		mediaTypeOCILayer:        true,
+		mediaTypeOCILayerGzip:    true,
+		mediaTypeOCILayerZstd:    true,
+		mediaTypeDockerLayer:     true,
+		mediaTypeDockerLayerGzip: true,
+	}
+)
+
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Platform    *ociPlatform      `json:"platform,omitempty"`
+}
+
+type ociPlatform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+}
+
+type ociIndex struct {
+	Manifests []ociDescriptor `json:"manifests"`
+}
+
+type ociManifest struct {
+	Layers []ociDescriptor `json:"layers"`
+}
+
+// fetchManifestFn returns verified content of the manifest or index.
+type fetchManifestFn func(desc ociDescriptor) ([]byte, error)
+
+// openBlobFn opens the blob to be copied to the cache.
+type openBlobFn func(desc ociDescriptor) (io.ReadCloser, error)
+
+// platformManifest follows indexes until the manifest of the image built for the platform of the host is found.
+func platformManifest(desc ociDescriptor, fetch fetchManifestFn) (ociManifest, error) {
+	for {
+		data, err := fetch(desc)
+		if err != nil {
+			return ociManifest{}, err
+		}
+
+		switch desc.MediaType {
+		case mediaTypeOCIManifest, mediaTypeDockerManifest:
+			var manifest ociManifest
+			if err := json.Unmarshal(data, &manifest); err != nil {
+				return ociManifest{}, errors.Wrapf(err, "decoding manifest %s failed", desc.Digest)
+			}
+			return manifest, nil
+		case mediaTypeOCIIndex, mediaTypeDockerList:
+			var index ociIndex
+			if err := json.Unmarshal(data, &index); err != nil {
+				return ociManifest{}, errors.Wrapf(err, "decoding index %s failed", desc.Digest)
+			}
+			platformDesc := matchPlatform(index.Manifests)
+			if platformDesc == nil {
+				return ociManifest{}, errors.Errorf("image for platform %s/%s not found", runtime.GOOS,
+					runtime.GOARCH)
+			}
+			desc = *platformDesc
+		default:
+			return ociManifest{}, errors.Errorf("unsupported media type %s of %s", desc.MediaType, desc.Digest)
+		}
+	}
+}
+
+// matchPlatform returns descriptor of the image built for the platform of the host.
+func matchPlatform(descs []ociDescriptor) *ociDescriptor {
+	for i, d := range descs {
+		if d.Platform == nil || (d.Platform.OS == runtime.GOOS && d.Platform.Architecture == runtime.GOARCH) {
+			return &descs[i]
+		}
+	}
+	return nil
+}
+
+// unpackLayers verifies and caches layers of the image and unpacks them inside directory.
+// Blobs are stored in the cache under their digests, so those existing there are verified already.
+func unpackLayers(ctx context.Context, cacheDir, dir string, layers []ociDescriptor, open openBlobFn) error {
+	blobsDir := filepath.Join(cacheDir, blobsCacheDir)
+	if err := os.MkdirAll(blobsDir, 0o700); err != nil {
+		return errors.WithStack(err)
+	}
+
+	messages := make([]interface{}, 0, len(layers))
+	for _, layer := range layers {
+		if !layerMediaTypes[layer.MediaType] {
+			return errors.Errorf("unsupported media type %s of layer %s", layer.MediaType, layer.Digest)
+		}
+		blob, err := cacheBlob(blobsDir, layer, open)
+		if err != nil {
+			return err
+		}
+		messages = append(messages, runner.Unpack{
+			Archive:   filepath.Join(blobsMountpoint, blob),
+			Whiteouts: true,
+		})
+	}
+
+	return execute(ctx, dir, wire.Config{
+		Mounts: []wire.Mount{
+			{
+				Host:      blobsDir,
+				Namespace: blobsMountpoint,
+			},
+		},
+	}, messages...)
+}
+
+// cacheBlob copies the blob to the cache verifying its digest and returns its path relative to the cache.
+func cacheBlob(blobsDir string, desc ociDescriptor, open openBlobFn) (retPath string, retErr error) {
+	hasher, expected, err := newDigestHasher(desc.Digest)
+	if err != nil {
+		return "", err
+	}
+	blob := digestPath(desc.Digest)
+	dstPath := filepath.Join(blobsDir, blob)
+	if _, err := os.Stat(dstPath); err == nil {
+		return blob, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(dstPath), 0o700); err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	src, err := open(desc)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	dst, err := os.CreateTemp(filepath.Dir(dstPath), ".tmp-*")
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	defer func() {
+		_ = dst.Close()
+		if retErr != nil {
+			_ = os.Remove(dst.Name())
+		}
+	}()
+
+	size, err := io.Copy(io.MultiWriter(dst, hasher), src)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	if size != desc.Size {
+		return "", errors.Errorf("blob %s is corrupted, expected size: %d, got: %d", desc.Digest, desc.Size, size)
+	}
+	if err := verifyDigest(hasher, expected, nil); err != nil {
+		return "", errors.WithMessagef(err, "blob %s is corrupted", desc.Digest)
+	}
+	if err := dst.Close(); err != nil {
+		return "", errors.WithStack(err)
+	}
+	return blob, errors.WithStack(os.Rename(dst.Name(), dstPath))
+}
+
+// readManifest reads manifest or index limiting its size and verifies its digest, if it's passed.
+func readManifest(r io.Reader, digest string) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxManifestSize+1))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if int64(len(data)) > maxManifestSize {
+		return nil, errors.New("manifest is too big")
+	}
+	if digest != "" {
+		hasher, expected, err := newDigestHasher(digest)
+		if err != nil {
+			return nil, err
+		}
+		if err := verifyDigest(hasher, expected, data); err != nil {
+			return nil, errors.WithMessagef(err, "manifest %s is corrupted", digest)
+		}
+	}
+	return data, nil
+}
+
+// digestPath returns path of the blob relative to the directory storing blobs, e.g. sha256/<hex>.
+func digestPath(digest string) string {
+	return strings.Replace(digest, ":", "/", 1)
+}
+
+// computeDigest returns sha256 digest of the data.
+func computeDigest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// newDigestHasher returns hasher computing the digest using algorithm and the expected encoded value.
+func newDigestHasher(digest string) (hash.Hash, string, error) {
+	algorithm, encoded, ok := strings.Cut(digest, ":")
+	if !ok {
+		return nil, "", errors.Errorf("digest '%s' is invalid", digest)
+	}
+
+	var hasher hash.Hash
+	var size int
+	switch algorithm {
+	case "sha256":
+		hasher, size = sha256.New(), sha256.Size
+	case "sha512":
+		hasher, size = sha512.New(), sha512.Size
+	default:
+		return nil, "", errors.Errorf("digest algorithm '%s' is not supported", algorithm)
+	}
+	if decoded, err := hex.DecodeString(encoded); err != nil || len(decoded) != size {
+		return nil, "", errors.Errorf("digest '%s' is invalid", digest)
+	}
+	return hasher, encoded, nil
+}
+
+func verifyDigest(hasher hash.Hash, expected string, data []byte) error {
+	hasher.Write(data)
+	if computed := hex.EncodeToString(hasher.Sum(nil)); computed != expected {
+		return errors.Errorf("digest doesn't match, expected: %s, got: %s", expected, computed)
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package base
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/outofforest/osman/infra/description"
+	"github.com/outofforest/osman/infra/types"
+)
+
+const ociRefNameKey = "org.opencontainers.image.ref.name"
+
+// NewOCIInitializer creates new initializer unpacking images stored in OCI image layout directories.
+func NewOCIInitializer() Initializer {
+	return &ociInitializer{}
+}
+
+type ociInitializer struct {
+}
+
+// BuildKey returns the key image is stored under, derived from the absolute path of the layout and the tag.
+// Digest pins the manifest the tag points to.
+func (i *ociInitializer) BuildKey(location, digest string) (types.BuildKey, error) {
+	layout, tag, err := parseOCILocation(location)
+	if err != nil {
+		return types.BuildKey{}, err
+	}
+	if tag == "" {
+		tag = description.DefaultTag
+	}
+	if digest != "" {
+		tag = types.Tag(digest)
+	}
+	return newBuildKey("oci-"+layout, tag)
+}
+
+// Init selects the manifest matching the tag and the platform of the host, verifies and caches its layers
+// and unpacks them inside directory. Digest of the manifest the tag points to is returned.
+func (i *ociInitializer) Init(ctx context.Context, cacheDir, dir, location, digest string) (string, error) {
+	layout, tag, err := parseOCILocation(location)
+	if err != nil {
+		return "", err
+	}
+
+	desc, err := ociTaggedManifest(layout, tag)
+	if err != nil {
+		return "", errors.WithMessagef(err, "selecting image in OCI layout %s failed", layout)
+	}
+	if digest != "" && desc.Digest != digest {
+		return "", errors.Errorf("image in OCI layout %s does not match the pin, expected: %s, got: %s", layout,
+			digest, desc.Digest)
+	}
+
+	manifest, err := platformManifest(desc, func(desc ociDescriptor) ([]byte, error) {
+		f, err := openOCIBlob(layout, desc)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return readManifest(f, desc.Digest)
+	})
+	if err != nil {
+		return "", errors.WithMessagef(err, "selecting image in OCI layout %s failed", layout)
+	}
+
+	if err := unpackLayers(ctx, cacheDir, dir, manifest.Layers, func(desc ociDescriptor) (io.ReadCloser, error) {
+		return openOCIBlob(layout, desc)
+	}); err != nil {
+		return "", err
+	}
+	return desc.Digest, nil
+}
+
+// Digest returns digest of the manifest the tag currently points to.
+func (i *ociInitializer) Digest(ctx context.Context, location string) (string, error) {
+	layout, tag, err := parseOCILocation(location)
+	if err != nil {
+		return "", err
+	}
+	desc, err := ociTaggedManifest(layout, tag)
+	if err != nil {
+		return "", err
+	}
+	return desc.Digest, nil
+}
+
+// parseOCILocation splits location into absolute path of the layout and optional tag, e.g. /srv/images/fedora:40.
+func parseOCILocation(location string) (string, types.Tag, error) {
+	layout, tag := location, types.Tag("")
+	if i := strings.LastIndex(location, ":"); i > strings.LastIndex(location, "/") {
+		layout, tag = location[:i], types.Tag(location[i+1:])
+		if !tag.IsValid() {
+			return "", "", errors.Errorf("tag '%s' of OCI image is invalid", tag)
+		}
+	}
+	layout, err := absDir(layout)
+	if err != nil {
+		return "", "", err
+	}
+	return layout, tag, nil
+}
+
+// ociTaggedManifest returns descriptor of the manifest or index tagged in the layout.
+// If tag is not specified, layout must contain exactly one image or the one tagged as latest.
+func ociTaggedManifest(layout string, tag types.Tag) (ociDescriptor, error) {
+	f, err := os.Open(filepath.Join(layout, "index.json"))
+	if err != nil {
+		return ociDescriptor{}, errors.WithStack(err)
+	}
+	defer f.Close()
+
+	data, err := readManifest(f, "")
+	if err != nil {
+		return ociDescriptor{}, err
+	}
+	var index ociIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		return ociDescriptor{}, errors.Wrap(err, "decoding index.json failed")
+	}
+
+	var refs []string
+	for _, d := range index.Manifests {
+		ref := d.Annotations[ociRefNameKey]
+		if ref != "" {
+			refs = append(refs, ref)
+		}
+		if ref == string(tag) || (tag == "" && ref == string(description.DefaultTag)) {
+			return d, nil
+		}
+	}
+	if tag == "" && len(index.Manifests) == 1 {
+		return index.Manifests[0], nil
+	}
+	if tag == "" {
+		tag = description.DefaultTag
+	}
+	return ociDescriptor{}, errors.Errorf("tag %s not found, available tags: %s", tag, strings.Join(refs, ", "))
+}
+
+func openOCIBlob(layout string, desc ociDescriptor) (io.ReadCloser, error) {
+	if _, _, err := newDigestHasher(desc.Digest); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(filepath.Join(layout, "blobs", digestPath(desc.Digest)))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return f, nil
+}
@@ -0,0 +1,49 @@
+package base
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	"github.com/outofforest/osman/infra/description"
+	"github.com/outofforest/osman/infra/types"
+)
+
+// NewOsmanInitializer creates new initializer of images built by osman.
+// It is used to reference them explicitly, so they are never taken from other sources.
+func NewOsmanInitializer() Initializer {
+	return &osmanInitializer{}
+}
+
+type osmanInitializer struct {
+}
+
+// BuildKey returns the key of the image.
+func (i *osmanInitializer) BuildKey(location, digest string) (types.BuildKey, error) {
+	if digest != "" {
+		return types.BuildKey{}, errPinNotSupported
+	}
+	buildKey, err := types.ParseBuildKey(location)
+	if err != nil {
+		return types.BuildKey{}, err
+	}
+	if buildKey.Tag == "" {
+		buildKey.Tag = description.DefaultTag
+	}
+	if !buildKey.IsValid() {
+		return types.BuildKey{}, errors.Errorf("image %s is invalid", buildKey)
+	}
+	return buildKey, nil
+}
+
+// Init is called only if image hasn't been built and there is no spec file defining it, so it always fails.
+func (i *osmanInitializer) Init(ctx context.Context, cacheDir, dir, location, digest string) (string, error) {
+	return "", errors.WithStack(fmt.Errorf("image %s has not been built and no spec file defines it: %w", location,
+		types.ErrImageDoesNotExist))
+}
+
+// Digest returns empty digest because images built by osman are not initialized.
+func (i *osmanInitializer) Digest(ctx context.Context, location string) (string, error) {
+	return "", nil
+}
@@ -0,0 +1,434 @@
+package base
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/outofforest/logger"
+	"github.com/outofforest/osman/config"
+)
+
+const (
+	dockerHubRegistry = "registry-1.docker.io"
+
+	registryAttempts   = 5
+	registryRetryAfter = 2 * time.Second
+
+	// tokenClientID identifies osman when identity token is exchanged for the access token.
+	tokenClientID = "osman"
+)
+
+// dockerHubAliases are the hosts used to refer docker hub, e.g. in docker config file.
+var dockerHubAliases = map[string]bool{
+	"docker.io":       true,
+	"index.docker.io": true,
+}
+
+// dockerImage identifies the image in the registry.
+type dockerImage struct {
+	// Registry is the host of the registry.
+	Registry string
+
+	// Repository is the path of the image inside the registry.
+	Repository string
+}
+
+func (i dockerImage) String() string {
+	return i.Registry + "/" + i.Repository
+}
+
+// newDockerImage splits image name into registry and repository. Images without registry are taken from docker hub.
+func newDockerImage(name string) dockerImage {
+	registry, repository, ok := strings.Cut(name, "/")
+	if !ok || (!strings.ContainsAny(registry, ".:") && registry != "localhost") {
+		registry, repository = dockerHubRegistry, name
+	}
+	registry = canonicalRegistry(registry)
+	if registry == dockerHubRegistry && !strings.Contains(repository, "/") {
+		repository = "library/" + repository
+	}
+	return dockerImage{Registry: registry, Repository: repository}
+}
+
+// canonicalRegistry returns the host used to access the registry.
+func canonicalRegistry(host string) string {
+	host = config.RegistryHost(host)
+	if dockerHubAliases[host] {
+		return dockerHubRegistry
+	}
+	return host
+}
+
+// registryEndpoint is the location image might be fetched from, registry itself or its mirror.
+type registryEndpoint struct {
+	// URL is the base URL of the registry API.
+	URL string
+
+	// Host is the host of the endpoint, used to find credentials.
+	Host string
+}
+
+// newRegistryClient creates client of docker registry API.
+func newRegistryClient(client *http.Client, registryConfig config.Registry) *registryClient {
+	c := &registryClient{
+		client:         client,
+		mirrors:        map[string][]string{},
+		insecure:       map[string]bool{},
+		auths:          map[string]config.RegistryAuth{},
+		authorizations: map[string]string{},
+	}
+	for host, mirrors := range registryConfig.Mirrors {
+		host = canonicalRegistry(host)
+		c.mirrors[host] = append(c.mirrors[host], mirrors...)
+	}
+	for host := range registryConfig.Insecure {
+		c.insecure[canonicalRegistry(host)] = true
+	}
+	for host, auth := range registryConfig.Auths {
+		c.auths[canonicalRegistry(host)] = auth
+	}
+	return c
+}
+
+type registryClient struct {
+	client   *http.Client
+	mirrors  map[string][]string
+	insecure map[string]bool
+	auths    map[string]config.RegistryAuth
+
+	mu             sync.Mutex
+	authorizations map[string]string
+}
+
+// endpoints returns locations the image is fetched from, mirrors first.
+func (c *registryClient) endpoints(registry string) []registryEndpoint {
+	mirrors := c.mirrors[registry]
+	endpoints := make([]registryEndpoint, 0, len(mirrors)+1)
+	for _, mirror := range mirrors {
+		host := canonicalRegistry(mirror)
+		mirrorURL := mirror
+		if !strings.Contains(mirror, "://") {
+			mirrorURL = c.scheme(host) + "://" + mirror
+		}
+		endpoints = append(endpoints, registryEndpoint{URL: mirrorURL, Host: host})
+	}
+	return append(endpoints, registryEndpoint{URL: c.scheme(registry) + "://" + registry, Host: registry})
+}
+
+// configured returns true if mirrors, credentials or plain HTTP are configured for the registry.
+func (c *registryClient) configured(registry string) bool {
+	_, auth := c.auths[registry]
+	return auth || c.insecure[registry] || len(c.mirrors[registry]) > 0
+}
+
+func (c *registryClient) scheme(host string) string {
+	if c.insecure[host] {
+		return "http"
+	}
+	return "https"
+}
+
+// manifest fetches manifest or index of the image referenced by tag or digest. It returns its descriptor
+// and verified content.
+func (c *registryClient) manifest(ctx context.Context, image dockerImage, reference string) (ociDescriptor, []byte,
+	error,
+) {
+	desc, data, err := c.fetchManifest(ctx, image, reference)
+	if err != nil {
+		return ociDescriptor{}, nil, errors.WithMessagef(err, "fetching manifest %s of image %s failed", reference,
+			image)
+	}
+	return desc, data, nil
+}
+
+// platformDigest returns digest of the manifest of the image built for the platform of the host,
+// following indexes the reference points to.
+func (c *registryClient) platformDigest(ctx context.Context, image dockerImage, reference string) (string, error) {
+	desc, data, err := c.manifest(ctx, image, reference)
+	if err != nil {
+		return "", err
+	}
+
+	platformDesc := desc
+	if _, err := platformManifest(desc, func(d ociDescriptor) ([]byte, error) {
+		platformDesc = d
+		if d.Digest == desc.Digest {
+			return data, nil
+		}
+		_, data, err := c.manifest(ctx, image, d.Digest)
+		return data, err
+	}); err != nil {
+		return "", errors.WithMessagef(err, "selecting manifest of image %s failed", image)
+	}
+	return platformDesc.Digest, nil
+}
+
+func (c *registryClient) fetchManifest(ctx context.Context, image dockerImage, reference string) (ociDescriptor,
+	[]byte, error,
+) {
+	resp, err := c.get(ctx, image, "manifests/"+reference, manifestMediaTypes)
+	if err != nil {
+		return ociDescriptor{}, nil, err
+	}
+	defer resp.Body.Close()
+
+	var expectedDigest string
+	if strings.Contains(reference, ":") {
+		expectedDigest = reference
+	}
+	data, err := readManifest(resp.Body, expectedDigest)
+	if err != nil {
+		return ociDescriptor{}, nil, err
+	}
+
+	desc := ociDescriptor{
+		MediaType: strings.TrimSpace(strings.Split(resp.Header.Get("Content-Type"), ";")[0]),
+		Digest:    computeDigest(data),
+		Size:      int64(len(data)),
+	}
+	if expectedDigest != "" {
+		desc.Digest = expectedDigest
+	} else if headerDigest := resp.Header.Get("Docker-Content-Digest"); strings.HasPrefix(headerDigest, "sha256:") &&
+		headerDigest != desc.Digest {
+		return ociDescriptor{}, nil, errors.Errorf("manifest is corrupted, expected digest: %s, got: %s",
+			headerDigest, desc.Digest)
+	}
+
+	// Some registries don't return precise content type, then it is taken from the manifest itself.
+	if desc.MediaType == "" || desc.MediaType == "application/json" {
+		var m struct {
+			MediaType string `json:"mediaType"`
+		}
+		if err := json.Unmarshal(data, &m); err != nil {
+			return ociDescriptor{}, nil, errors.WithStack(err)
+		}
+		desc.MediaType = m.MediaType
+	}
+	return desc, data, nil
+}
+
+// blob returns the stream of the blob content. Caller is responsible for verifying its digest.
+func (c *registryClient) blob(ctx context.Context, image dockerImage, digest string) (io.ReadCloser, error) {
+	resp, err := c.get(ctx, image, "blobs/"+digest, nil)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "fetching blob %s of image %s failed", digest, image)
+	}
+	return resp.Body, nil
+}
+
+// get sends request to the registry, trying its mirrors first. Caller is responsible for closing the body
+// of returned response.
+func (c *registryClient) get(ctx context.Context, image dockerImage, path string, accept []string) (*http.Response,
+	error,
+) {
+	endpoints := c.endpoints(image.Registry)
+
+	var err error
+	for i, endpoint := range endpoints {
+		var resp *http.Response
+		resp, err = c.getFrom(ctx, endpoint, image, path, accept)
+		if err == nil {
+			return resp, nil
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		if i < len(endpoints)-1 {
+			logger.Get(ctx).Warn("Request to registry mirror failed, trying next one",
+				zap.String("mirror", endpoint.URL), zap.Error(err))
+		}
+	}
+	return nil, err
+}
+
+// getFrom sends request to the endpoint retrying on temporary failures and authorizing when requested.
+func (c *registryClient) getFrom(ctx context.Context, endpoint registryEndpoint, image dockerImage, path string,
+	accept []string,
+) (*http.Response, error) {
+	requestURL := fmt.Sprintf("%s/v2/%s/%s", endpoint.URL, image.Repository, path)
+	log := logger.Get(ctx).With(zap.String("url", requestURL))
+
+	var err error
+	for attempt := 1; attempt <= registryAttempts; attempt++ {
+		if attempt > 1 {
+			log.Warn("Request to registry failed, retrying", zap.Error(err))
+			select {
+			case <-ctx.Done():
+				return nil, errors.WithStack(ctx.Err())
+			case <-time.After(registryRetryAfter * time.Duration(attempt-1)):
+			}
+		}
+
+		var resp *http.Response
+		var retry bool
+		resp, retry, err = c.try(ctx, endpoint, image, requestURL, accept)
+		if err == nil || !retry {
+			return resp, err
+		}
+	}
+	return nil, err
+}
+
+func (c *registryClient) try(ctx context.Context, endpoint registryEndpoint, image dockerImage, requestURL string,
+	accept []string,
+) (*http.Response, bool, error) {
+	scope := "repository:" + image.Repository + ":pull"
+	for authorized := false; ; authorized = true {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, requestURL, nil)
+		if err != nil {
+			return nil, false, errors.WithStack(err)
+		}
+		for _, a := range accept {
+			req.Header.Add("Accept", a)
+		}
+		if authorization := c.authorization(endpoint.Host, scope); authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+
+		resp, err := c.client.Do(req)
+		if err != nil {
+			return nil, ctx.Err() == nil, errors.WithStack(err)
+		}
+
+		switch {
+		case resp.StatusCode == http.StatusOK:
+			return resp, false, nil
+		case resp.StatusCode == http.StatusUnauthorized && !authorized:
+			challenge := resp.Header.Get("WWW-Authenticate")
+			resp.Body.Close()
+			if err := c.authorize(ctx, endpoint.Host, challenge, scope); err != nil {
+				return nil, false, err
+			}
+		default:
+			resp.Body.Close()
+			retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError
+			return nil, retry, errors.Errorf("unexpected response status: %s", resp.Status)
+		}
+	}
+}
+
+func (c *registryClient) authorization(host, scope string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.authorizations[host+" "+scope]
+}
+
+// authorize computes the value of Authorization header required by the challenge. Bearer token is obtained
+// from the authorization server pointed by the challenge.
+func (c *registryClient) authorize(ctx context.Context, host, challenge, scope string) error {
+	auth, hasAuth := c.auths[host]
+
+	var authorization string
+	scheme, params := parseChallenge(challenge)
+	switch {
+	case strings.EqualFold(scheme, "basic"):
+		if !hasAuth {
+			return errors.Errorf("registry %s requires credentials", host)
+		}
+		authorization = "Basic " + base64.StdEncoding.EncodeToString([]byte(auth.Username+":"+auth.Password))
+	case strings.EqualFold(scheme, "bearer") && params["realm"] != "":
+		token, err := c.token(ctx, params, scope, auth)
+		if err != nil {
+			return err
+		}
+		authorization = "Bearer " + token
+	default:
+		return errors.Errorf("unsupported authorization challenge '%s'", challenge)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.authorizations[host+" "+scope] = authorization
+	return nil
+}
+
+// token obtains bearer token from the authorization server. Identity token is exchanged using OAuth2 flow,
+// otherwise username and password, if present, are sent using basic authentication.
+func (c *registryClient) token(ctx context.Context, params map[string]string, scope string,
+	auth config.RegistryAuth,
+) (string, error) {
+	query := url.Values{}
+	if service := params["service"]; service != "" {
+		query.Set("service", service)
+	}
+	query.Set("scope", scope)
+
+	var req *http.Request
+	var err error
+	if auth.IdentityToken != "" {
+		query.Set("grant_type", "refresh_token")
+		query.Set("refresh_token", auth.IdentityToken)
+		query.Set("client_id", tokenClientID)
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, params["realm"],
+			strings.NewReader(query.Encode()))
+		if err != nil {
+			return "", errors.WithStack(err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, params["realm"], nil)
+		if err != nil {
+			return "", errors.WithStack(err)
+		}
+		req.URL.RawQuery = query.Encode()
+		if auth.Username != "" {
+			req.SetBasicAuth(auth.Username, auth.Password)
+		}
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Errorf("authorization failed: %s", resp.Status)
+	}
+
+	var data struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"` //nolint:tagliatelle
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return "", errors.WithStack(err)
+	}
+	token := data.Token
+	if token == "" {
+		token = data.AccessToken
+	}
+	if token == "" {
+		return "", errors.New("no token in authorization response")
+	}
+	return token, nil
+}
+
+// parseChallenge parses WWW-Authenticate header, e.g. Bearer realm="https://auth.docker.io/token",service="x".
+func parseChallenge(challenge string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(challenge), " ")
+	params := map[string]string{}
+	for rest != "" {
+		var key, value string
+		key, rest, _ = strings.Cut(strings.TrimLeft(rest, " ,"), "=")
+		if strings.HasPrefix(rest, `"`) {
+			value, rest, _ = strings.Cut(rest[1:], `"`)
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+		params[strings.ToLower(strings.TrimSpace(key))] = value
+	}
+	return scheme, params
+}
@@ -0,0 +1,371 @@
+package base
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/outofforest/logger"
+	"github.com/outofforest/osman/config"
+)
+
+const testRepository = "team/app"
+
+var testManifest = []byte(`{"mediaType":"application/vnd.oci.image.manifest.v1+json","layers":[]}`)
+
+func newTestContext() context.Context {
+	return logger.WithLogger(context.Background(), zap.NewNop())
+}
+
+// newTestRegistry starts registry stand-in serving test manifest under tag latest. Authorize decides if request
+// is accepted, if it returns false, 401 with the challenge is sent.
+func newTestRegistry(t *testing.T, challenge string, authorize func(r *http.Request) bool) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if authorize != nil && !authorize(r) {
+			w.Header().Set("WWW-Authenticate", challenge)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/v2/"+testRepository+"/manifests/latest" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", mediaTypeOCIManifest)
+		w.Header().Set("Docker-Content-Digest", computeDigest(testManifest))
+		_, _ = w.Write(testManifest)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func serverHost(server *httptest.Server) string {
+	return config.RegistryHost(server.URL)
+}
+
+func newTestClient(registryConfig config.Registry) *registryClient {
+	return newRegistryClient(&http.Client{}, registryConfig)
+}
+
+func fetchTestManifest(t *testing.T, client *registryClient, host string) error {
+	t.Helper()
+
+	desc, data, err := client.manifest(newTestContext(), newDockerImage(host+"/"+testRepository), "latest")
+	if err != nil {
+		return err
+	}
+	if desc.MediaType != mediaTypeOCIManifest {
+		t.Errorf("unexpected media type: %s", desc.MediaType)
+	}
+	if desc.Digest != computeDigest(testManifest) {
+		t.Errorf("unexpected digest: %s", desc.Digest)
+	}
+	if string(data) != string(testManifest) {
+		t.Errorf("unexpected manifest: %s", data)
+	}
+	return nil
+}
+
+func TestRegistryInsecure(t *testing.T) {
+	registry := newTestRegistry(t, "", nil)
+	host := serverHost(registry)
+
+	client := newTestClient(config.Registry{Insecure: map[string]bool{host: true}})
+	endpoints := client.endpoints(host)
+	if len(endpoints) != 1 || endpoints[0].URL != "http://"+host {
+		t.Fatalf("unexpected endpoints: %v", endpoints)
+	}
+	if err := fetchTestManifest(t, client, host); err != nil {
+		t.Fatal(err)
+	}
+
+	endpoints = newTestClient(config.Registry{}).endpoints(host)
+	if len(endpoints) != 1 || endpoints[0].URL != "https://"+host {
+		t.Fatalf("unexpected endpoints: %v", endpoints)
+	}
+}
+
+func TestRegistryMirrorFallback(t *testing.T) {
+	registry := newTestRegistry(t, "", nil)
+	host := serverHost(registry)
+
+	var mirrorRequests int32
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&mirrorRequests, 1)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	t.Cleanup(mirror.Close)
+
+	client := newTestClient(config.Registry{
+		Mirrors:  map[string][]string{host: {mirror.URL}},
+		Insecure: map[string]bool{host: true},
+	})
+	endpoints := client.endpoints(host)
+	if len(endpoints) != 2 || endpoints[0].URL != mirror.URL || endpoints[1].URL != "http://"+host {
+		t.Fatalf("unexpected endpoints: %v", endpoints)
+	}
+
+	if err := fetchTestManifest(t, client, host); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(&mirrorRequests) != 1 {
+		t.Fatalf("mirror should be tried once, tried: %d", mirrorRequests)
+	}
+}
+
+func TestRegistryMirrorFirst(t *testing.T) {
+	var registryRequests int32
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&registryRequests, 1)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	t.Cleanup(registry.Close)
+	host := serverHost(registry)
+
+	mirror := newTestRegistry(t, "", nil)
+
+	client := newTestClient(config.Registry{
+		Mirrors:  map[string][]string{host: {mirror.URL}},
+		Insecure: map[string]bool{host: true},
+	})
+	if err := fetchTestManifest(t, client, host); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(&registryRequests) != 0 {
+		t.Fatalf("registry shouldn't be tried if mirror succeeds, tried: %d", registryRequests)
+	}
+}
+
+func TestRegistryBasicChallenge(t *testing.T) {
+	registry := newTestRegistry(t, `Basic realm="test"`, func(r *http.Request) bool {
+		username, password, ok := r.BasicAuth()
+		return ok && username == "user" && password == "pass"
+	})
+	host := serverHost(registry)
+
+	client := newTestClient(config.Registry{
+		Insecure: map[string]bool{host: true},
+		Auths:    map[string]config.RegistryAuth{host: {Username: "user", Password: "pass"}},
+	})
+	if err := fetchTestManifest(t, client, host); err != nil {
+		t.Fatal(err)
+	}
+
+	client = newTestClient(config.Registry{Insecure: map[string]bool{host: true}})
+	err := fetchTestManifest(t, client, host)
+	if err == nil || !strings.Contains(err.Error(), "requires credentials") {
+		t.Fatalf("missing credentials should be reported, got: %v", err)
+	}
+}
+
+func TestRegistryBearerChallenge(t *testing.T) {
+	const token = "test-token"
+
+	var tokenRequests int32
+	auth := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&tokenRequests, 1)
+		query := r.URL.Query()
+		username, password, ok := r.BasicAuth()
+		if !ok || username != "user" || password != "pass" || query.Get("service") != "test-registry" ||
+			query.Get("scope") != "repository:"+testRepository+":pull" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"token": token})
+	}))
+	t.Cleanup(auth.Close)
+
+	registry := newTestRegistry(t, `Bearer realm="`+auth.URL+`/token",service="test-registry"`,
+		func(r *http.Request) bool {
+			return r.Header.Get("Authorization") == "Bearer "+token
+		})
+	host := serverHost(registry)
+
+	client := newTestClient(config.Registry{
+		Insecure: map[string]bool{host: true},
+		Auths:    map[string]config.RegistryAuth{host: {Username: "user", Password: "pass"}},
+	})
+	for range 2 {
+		if err := fetchTestManifest(t, client, host); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if atomic.LoadInt32(&tokenRequests) != 1 {
+		t.Fatalf("token should be reused, requested: %d", tokenRequests)
+	}
+}
+
+func TestParseChallenge(t *testing.T) {
+	tests := []struct {
+		challenge string
+		scheme    string
+		params    map[string]string
+	}{
+		{
+			challenge: `Basic realm="Registry Realm"`,
+			scheme:    "Basic",
+			params:    map[string]string{"realm": "Registry Realm"},
+		},
+		{
+			challenge: `Bearer realm="https://auth.docker.io/token",service="registry.docker.io",` +
+				`scope="repository:library/fedora:pull"`,
+			scheme: "Bearer",
+			params: map[string]string{
+				"realm":   "https://auth.docker.io/token",
+				"service": "registry.docker.io",
+				"scope":   "repository:library/fedora:pull",
+			},
+		},
+		{
+			challenge: `Bearer Realm=https://auth.example.com/token, service=example`,
+			scheme:    "Bearer",
+			params: map[string]string{
+				"realm":   "https://auth.example.com/token",
+				"service": "example",
+			},
+		},
+		{
+			challenge: "",
+			scheme:    "",
+			params:    map[string]string{},
+		},
+	}
+
+	for _, test := range tests {
+		scheme, params := parseChallenge(test.challenge)
+		if scheme != test.scheme {
+			t.Errorf("challenge '%s': unexpected scheme: %s", test.challenge, scheme)
+		}
+		if len(params) != len(test.params) {
+			t.Errorf("challenge '%s': unexpected params: %v", test.challenge, params)
+			continue
+		}
+		for k, v := range test.params {
+			if params[k] != v {
+				t.Errorf("challenge '%s': unexpected value of %s: %s", test.challenge, k, params[k])
+			}
+		}
+	}
+}
+
+func TestRegistryDigestMismatch(t *testing.T) {
+	const wrongDigest = "sha256:0000000000000000000000000000000000000000000000000000000000000000"
+
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", mediaTypeOCIManifest)
+		w.Header().Set("Docker-Content-Digest", wrongDigest)
+		_, _ = w.Write(testManifest)
+	}))
+	t.Cleanup(registry.Close)
+	host := serverHost(registry)
+
+	client := newTestClient(config.Registry{Insecure: map[string]bool{host: true}})
+	image := newDockerImage(host + "/" + testRepository)
+
+	if _, _, err := client.manifest(newTestContext(), image, "latest"); err == nil {
+		t.Fatal("manifest not matching the digest reported by registry should be rejected")
+	}
+	if _, _, err := client.manifest(newTestContext(), image, wrongDigest); err == nil {
+		t.Fatal("manifest not matching the requested digest should be rejected")
+	}
+
+	blobsDir := t.TempDir()
+	_, err := cacheBlob(blobsDir, ociDescriptor{
+		MediaType: mediaTypeOCILayer,
+		Digest:    wrongDigest,
+		Size:      int64(len(testManifest)),
+	}, func(desc ociDescriptor) (io.ReadCloser, error) {
+		return client.blob(newTestContext(), image, desc.Digest)
+	})
+	if err == nil {
+		t.Fatal("blob not matching the digest should be rejected")
+	}
+	if _, err := os.Stat(filepath.Join(blobsDir, digestPath(wrongDigest))); !os.IsNotExist(err) {
+		t.Fatal("rejected blob shouldn't be cached")
+	}
+}
+
+func TestRegistryMultiArchDigest(t *testing.T) {
+	platformManifestData := []byte(`{"mediaType":"application/vnd.oci.image.manifest.v1+json","layers":[],` +
+		`"annotations":{"arch":"` + runtime.GOARCH + `"}}`)
+	otherManifestData := []byte(`{"mediaType":"application/vnd.oci.image.manifest.v1+json","layers":[],` +
+		`"annotations":{"arch":"other"}}`)
+	index, err := json.Marshal(ociIndex{Manifests: []ociDescriptor{
+		{
+			MediaType: mediaTypeOCIManifest,
+			Digest:    computeDigest(otherManifestData),
+			Size:      int64(len(otherManifestData)),
+			Platform:  &ociPlatform{OS: runtime.GOOS, Architecture: "other"},
+		},
+		{
+			MediaType: mediaTypeOCIManifest,
+			Digest:    computeDigest(platformManifestData),
+			Size:      int64(len(platformManifestData)),
+			Platform:  &ociPlatform{OS: runtime.GOOS, Architecture: runtime.GOARCH},
+		},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifests := map[string][]byte{
+		"latest":                            index,
+		computeDigest(index):                index,
+		computeDigest(platformManifestData): platformManifestData,
+		computeDigest(otherManifestData):    otherManifestData,
+	}
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, exists := manifests[strings.TrimPrefix(r.URL.Path, "/v2/"+testRepository+"/manifests/")]
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		mediaType := mediaTypeOCIManifest
+		if string(data) == string(index) {
+			mediaType = mediaTypeOCIIndex
+		}
+		w.Header().Set("Content-Type", mediaType)
+		_, _ = w.Write(data)
+	}))
+	t.Cleanup(registry.Close)
+	host := serverHost(registry)
+
+	client := newTestClient(config.Registry{Insecure: map[string]bool{host: true}})
+	image := newDockerImage(host + "/" + testRepository)
+
+	// Executor records the digest of the manifest it inflates, so the same one must be reported for multi-arch tag.
+	digest, err := client.platformDigest(newTestContext(), image, "latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if digest != computeDigest(platformManifestData) {
+		t.Fatalf("digest of the platform manifest expected, got: %s", digest)
+	}
+
+	digest, err = client.platformDigest(newTestContext(), image, computeDigest(platformManifestData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if digest != computeDigest(platformManifestData) {
+		t.Fatalf("digest of the platform manifest expected, got: %s", digest)
+	}
+
+	desc, _, err := client.manifest(newTestContext(), image, "latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if desc.Digest != computeDigest(index) {
+		t.Fatalf("digest of the index expected, got: %s", desc.Digest)
+	}
+}
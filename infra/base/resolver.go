@@ -0,0 +1,69 @@
+package base
+
+import (
+	"context"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/outofforest/ioc/v2"
+	"github.com/outofforest/osman/infra/types"
+)
+
+// NewResolvingInitializer returns initializer selecting the one registered for the scheme of base image reference.
+// Locations passed to it are references in form <scheme>:<location>.
+func NewResolvingInitializer(c *ioc.Container) Initializer {
+	return &resolvingInitializer{
+		c: c,
+	}
+}
+
+type resolvingInitializer struct {
+	c *ioc.Container
+}
+
+// BuildKey returns the key base image is stored under.
+func (i *resolvingInitializer) BuildKey(ref, digest string) (types.BuildKey, error) {
+	initializer, location, err := i.resolve(ref)
+	if err != nil {
+		return types.BuildKey{}, err
+	}
+	buildKey, err := initializer.BuildKey(location, digest)
+	if err != nil {
+		return types.BuildKey{}, errors.WithMessagef(err, "invalid base image %s", ref)
+	}
+	return buildKey, nil
+}
+
+// Init installs base image inside directory using initializer matching the scheme.
+func (i *resolvingInitializer) Init(ctx context.Context, cacheDir, dir, ref, digest string) (string, error) {
+	initializer, location, err := i.resolve(ref)
+	if err != nil {
+		return "", err
+	}
+	return initializer.Init(ctx, cacheDir, dir, location, digest)
+}
+
+// Digest returns digest of the content currently found at location using initializer matching the scheme.
+func (i *resolvingInitializer) Digest(ctx context.Context, ref string) (string, error) {
+	initializer, location, err := i.resolve(ref)
+	if err != nil {
+		return "", err
+	}
+	return initializer.Digest(ctx, location)
+}
+
+func (i *resolvingInitializer) resolve(ref string) (Initializer, string, error) {
+	scheme, location, err := types.ParseBaseReference(ref)
+	if err != nil {
+		return nil, "", err
+	}
+	if !i.c.NameExists(scheme, (*Initializer)(nil)) {
+		return nil, "", errors.Errorf("unknown scheme '%s' of base image %s, supported schemes are: %s", scheme, ref,
+			strings.Join(i.c.Names((*Initializer)(nil)), ", "))
+	}
+
+	var initializer Initializer
+	i.c.ResolveNamed(scheme, &initializer)
+	return initializer, location, nil
+}
@@ -0,0 +1,39 @@
+package base
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/outofforest/osman/infra/description"
+	"github.com/outofforest/osman/infra/types"
+)
+
+// NewScratchInitializer creates new initializer of the empty base image.
+func NewScratchInitializer() Initializer {
+	return &scratchInitializer{}
+}
+
+type scratchInitializer struct {
+}
+
+// BuildKey returns the key of the empty image.
+func (i *scratchInitializer) BuildKey(location, digest string) (types.BuildKey, error) {
+	if location != "" {
+		return types.BuildKey{}, errors.New("scratch image does not accept location")
+	}
+	if digest != "" {
+		return types.BuildKey{}, errPinNotSupported
+	}
+	return types.NewBuildKey(scratchName, description.DefaultTag), nil
+}
+
+// Init does nothing because image is empty.
+func (i *scratchInitializer) Init(ctx context.Context, cacheDir, dir, location, digest string) (string, error) {
+	return "", nil
+}
+
+// Digest returns empty digest because image is empty.
+func (i *scratchInitializer) Digest(ctx context.Context, location string) (string, error) {
+	return "", nil
+}
@@ -0,0 +1,117 @@
+package base
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+
+	"github.com/outofforest/isolator/wire"
+	"github.com/outofforest/osman/infra/description"
+	"github.com/outofforest/osman/infra/runner"
+	"github.com/outofforest/osman/infra/types"
+)
+
+// NewTarInitializer creates new initializer unpacking root filesystem from tarball.
+// Uncompressed, gzip and zstd compressed tarballs are supported.
+func NewTarInitializer() Initializer {
+	return &tarInitializer{}
+}
+
+type tarInitializer struct {
+}
+
+// BuildKey returns the key image is stored under, derived from the absolute path of the tarball.
+// If digest is not set, content of the tarball is not tracked, so changes to it are taken into account only when
+// image is rebuilt.
+func (i *tarInitializer) BuildKey(location, digest string) (types.BuildKey, error) {
+	file, err := absFile(location)
+	if err != nil {
+		return types.BuildKey{}, err
+	}
+	tag := description.DefaultTag
+	if digest != "" {
+		tag = types.Tag(digest)
+	}
+	return newBuildKey("tar-"+file, tag)
+}
+
+// Init unpacks tarball inside directory preserving owners, extended attributes and device nodes.
+func (i *tarInitializer) Init(ctx context.Context, cacheDir, dir, location, digest string) (string, error) {
+	file, err := absFile(location)
+	if err != nil {
+		return "", err
+	}
+
+	fileDigest, err := fileSHA256(file)
+	if err != nil {
+		return "", err
+	}
+	if digest != "" && fileDigest != digest {
+		return "", errors.Errorf("tarball %s does not match the pin, expected: %s, got: %s", file, digest,
+			fileDigest)
+	}
+
+	// Digest is verified once again while unpacking, in case file has been modified in the meantime.
+	err = execute(ctx, dir, wire.Config{
+		Mounts: []wire.Mount{
+			{
+				Host:      filepath.Dir(file),
+				Namespace: baseMountpoint,
+			},
+		},
+	}, runner.Unpack{
+		Archive: filepath.Join(baseMountpoint, filepath.Base(file)),
+		Digest:  fileDigest,
+	})
+	if err != nil {
+		return "", errors.WithMessagef(err, "unpacking tarball %s failed", file)
+	}
+	return fileDigest, nil
+}
+
+// Digest returns digest of the tarball.
+func (i *tarInitializer) Digest(ctx context.Context, location string) (string, error) {
+	file, err := absFile(location)
+	if err != nil {
+		return "", err
+	}
+	return fileSHA256(file)
+}
+
+// absFile returns absolute path of the existing file. Relative paths are relative to the spec file directory.
+func absFile(location string) (string, error) {
+	if location == "" {
+		return "", errors.New("file is not specified")
+	}
+	file, err := filepath.Abs(location)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	if info.IsDir() {
+		return "", errors.Errorf("%s is a directory", file)
+	}
+	return file, nil
+}
+
+func fileSHA256(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	defer f.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, f); err != nil {
+		return "", errors.WithStack(err)
+	}
+	return "sha256:" + hex.EncodeToString(hasher.Sum(nil)), nil
+}
@@ -2,12 +2,59 @@ package base
 
 import (
 	"context"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
 
 	"github.com/outofforest/osman/infra/types"
 )
 
+const (
+	scratchName = "scratch"
+
+	// baseMountpoint is the directory where host directory containing base image is mounted.
+	baseMountpoint = "/.base"
+)
+
 // Initializer initializes base image.
 type Initializer interface {
-	// Init installs base image inside directory.
-	Init(ctx context.Context, cacheDir, dir string, buildKey types.BuildKey) error
+	// BuildKey returns the key base image found at location is stored under.
+	// If digest is not empty, it pins the content of the image.
+	BuildKey(location, digest string) (types.BuildKey, error)
+
+	// Init installs base image found at location inside directory and returns the digest of its content.
+	// If digest is not empty, content must match it.
+	Init(ctx context.Context, cacheDir, dir, location, digest string) (string, error)
+
+	// Digest returns the digest of the content currently found at location, without installing it.
+	// Empty digest is returned if content can't be verified.
+	Digest(ctx context.Context, location string) (string, error)
+}
+
+// Reference returns reference to the base image identified only by its build key.
+// Such images are taken from docker registry, except scratch being the empty one. Tags being digests,
+// like in fedora@sha256:<hex>, pin the image.
+func Reference(buildKey types.BuildKey) string {
+	if buildKey.Name == scratchName {
+		return "scratch:"
+	}
+	if _, _, err := newDigestHasher(string(buildKey.Tag)); err == nil {
+		return "docker://" + buildKey.Name + "@" + string(buildKey.Tag)
+	}
+	return "docker://" + buildKey.Name + ":" + string(buildKey.Tag)
+}
+
+// errPinNotSupported is returned by initializers which can't verify the content of the image.
+var errPinNotSupported = errors.New("pinning the content by digest is not supported")
+
+var invalidNameCharsRegExp = regexp.MustCompile(`[^a-zA-Z0-9\-_.:]+`)
+
+// newBuildKey returns the key base image is stored under, derived from its location.
+func newBuildKey(location string, tag types.Tag) (types.BuildKey, error) {
+	buildKey := types.NewBuildKey(invalidNameCharsRegExp.ReplaceAllString(strings.Trim(location, "/"), "_"), tag)
+	if !buildKey.IsValid() {
+		return types.BuildKey{}, errors.Errorf("base image %s can't be stored as %s", location, buildKey)
+	}
+	return buildKey, nil
 }
@@ -2,8 +2,12 @@ package infra
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 
@@ -11,12 +15,25 @@ import (
 	"github.com/outofforest/isolator/wire"
 	"github.com/outofforest/osman/config"
 	"github.com/outofforest/osman/infra/base"
+	"github.com/outofforest/osman/infra/buildlog"
+	"github.com/outofforest/osman/infra/cache"
+	"github.com/outofforest/osman/infra/cgroup"
 	"github.com/outofforest/osman/infra/description"
 	"github.com/outofforest/osman/infra/parser"
+	"github.com/outofforest/osman/infra/progress"
+	"github.com/outofforest/osman/infra/runner"
 	"github.com/outofforest/osman/infra/storage"
 	"github.com/outofforest/osman/infra/types"
 )
 
+const (
+	specDirMountpoint = "/.specdir"
+	cacheMountpoint   = "/.buildcache"
+
+	// failedTagPrefix is the prefix of the tag assigned to kept failed builds.
+	failedTagPrefix = "failed-"
+)
+
 // NewBuilder creates new image builder.
 func NewBuilder(
 	config config.Build,
@@ -24,26 +41,57 @@ func NewBuilder(
 	repo *Repository,
 	storage storage.Driver,
 	parser parser.Parser,
+	reporter progress.Reporter,
 ) *Builder {
+	var deadline time.Time
+	if config.Timeout > 0 {
+		deadline = time.Now().Add(config.Timeout)
+	}
 	return &Builder{
 		rebuild:     config.Rebuild,
+		keepFailed:  config.KeepFailed,
+		ifStale:     config.IfStale,
+		locked:      config.Locked,
+		timeout:     config.Timeout,
+		deadline:    deadline,
+		limits:      config.Limits,
+		network:     description.Network(config.Network),
+		secrets:     config.Secrets,
+		labels:      config.Labels,
 		readyBuilds: map[types.BuildKey]bool{},
+		stages:      map[types.BuildKey]bool{},
+		bases:       map[types.BuildKey]types.BaseSource{},
+		staleness:   NewStalenessChecker(storage),
 		initializer: initializer,
 		repo:        repo,
 		storage:     storage,
 		parser:      parser,
+		reporter:    reporter,
 	}
 }
 
 // Builder builds images.
 type Builder struct {
 	rebuild     bool
+	keepFailed  bool
+	ifStale     bool
+	locked      bool
+	timeout     time.Duration
+	deadline    time.Time
+	limits      types.Limits
+	network     description.Network
+	secrets     map[string]string
+	labels      types.Labels
 	readyBuilds map[types.BuildKey]bool
+	stages      map[types.BuildKey]bool
+	bases       map[types.BuildKey]types.BaseSource
+	staleness   *StalenessChecker
 
 	initializer base.Initializer
 	repo        *Repository
 	storage     storage.Driver
 	parser      parser.Parser
+	reporter    progress.Reporter
 }
 
 // BuildFromFile builds image from spec file.
@@ -54,12 +102,60 @@ func (b *Builder) BuildFromFile(
 	name string,
 	tags ...types.Tag,
 ) (types.BuildID, error) {
-	return b.buildFromFile(ctx, cacheDir, map[types.BuildKey]bool{}, specFile, name, tags...)
+	commands, err := b.parser.Parse(specFile)
+	if err != nil {
+		return "", err
+	}
+	path := specPath(specFile, commands)
+
+	if b.ifStale && !b.rebuild {
+		buildID, upToDate, err := b.upToDate(ctx, path, name, tags)
+		if err != nil {
+			return "", err
+		}
+		if upToDate {
+			keys := make([]types.BuildKey, 0, len(tags))
+			for _, tag := range tags {
+				keys = append(keys, types.NewBuildKey(name, tag))
+			}
+			b.reporter.Report(progress.Event{
+				Type:      progress.EventCacheHit,
+				Time:      time.Now().UTC(),
+				BuildID:   buildID,
+				BuildKeys: keys,
+			})
+			return buildID, nil
+		}
+	}
+
+	return b.build(ctx, cacheDir, map[types.BuildKey]bool{}, path,
+		b.withLabels(description.Describe(name, tags, commands...)))
 }
 
 // Build builds images.
 func (b *Builder) Build(ctx context.Context, cacheDir string, img *description.Descriptor) (types.BuildID, error) {
-	return b.build(ctx, cacheDir, map[types.BuildKey]bool{}, img)
+	return b.build(ctx, cacheDir, map[types.BuildKey]bool{}, "", b.withLabels(img))
+}
+
+// withLabels appends labels passed to the builder to the requested image, so they override the ones defined by it.
+// Parent images are not affected.
+func (b *Builder) withLabels(img *description.Descriptor) *description.Descriptor {
+	commands := img.Commands()
+	if len(b.labels) == 0 || len(commands) == 0 {
+		return img
+	}
+
+	keys := make([]string, 0, len(b.labels))
+	for key := range b.labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	commands = append(make([]description.Command, 0, len(commands)+len(keys)), commands...)
+	for _, key := range keys {
+		commands = append(commands, description.Label(key, b.labels[key]))
+	}
+	return description.Describe(img.Name(), img.Tags(), commands...)
 }
 
 func (b *Builder) buildFromFile(
@@ -73,30 +169,156 @@ func (b *Builder) buildFromFile(
 	if err != nil {
 		return "", err
 	}
-	return b.build(ctx, cacheDir, stack, description.Describe(name, tags, commands...))
+	return b.build(ctx, cacheDir, stack, specPath(specFile, commands), description.Describe(name, tags, commands...))
+}
+
+// buildFromSpecDir builds image from spec file stored in another directory.
+// Spec file directory is mounted inside the build, so it must be the working directory while building.
+func (b *Builder) buildFromSpecDir(
+	ctx context.Context,
+	cacheDir string,
+	stack map[types.BuildKey]bool,
+	specFile, name string,
+	tag types.Tag,
+) (retBuildID types.BuildID, retErr error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	if err := os.Chdir(filepath.Dir(specFile)); err != nil {
+		return "", errors.WithStack(err)
+	}
+	defer func() {
+		if err := os.Chdir(wd); err != nil && retErr == nil {
+			retErr = errors.WithStack(err)
+		}
+	}()
+
+	return b.buildFromFile(ctx, cacheDir, stack, specFile, name, tag)
+}
+
+// withDeadline applies build timeout to the context used to execute build steps.
+// Storage operations use the original context, so the partial build can still be dropped after timeout.
+func (b *Builder) withDeadline(ctx context.Context) (context.Context, context.CancelFunc) {
+	if b.deadline.IsZero() {
+		return context.WithCancel(ctx)
+	}
+	return context.WithDeadline(ctx, b.deadline)
+}
+
+// timeoutError explains the error caused by exceeded build timeout.
+func (b *Builder) timeoutError(ctx, execCtx context.Context, err error) error {
+	if errors.Is(execCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil {
+		return errors.WithMessagef(err, "build timeout of %s exceeded", b.timeout)
+	}
+	return err
 }
 
+// initialize installs base image inside directory and returns its source.
 func (b *Builder) initialize(
 	ctx context.Context,
 	cacheDir string,
 	buildKey types.BuildKey,
 	path string,
-) (retErr error) {
-	if buildKey.Name == "scratch" {
-		return nil
+) (types.BaseSource, error) {
+	source, exists := b.bases[buildKey]
+	if !exists {
+		source = types.BaseSource{Reference: base.Reference(buildKey)}
 	}
 	// Permissions on path dir has to be set to 755 to allow read access for everyone so linux boots correctly.
-	return b.initializer.Init(ctx, cacheDir, path, buildKey)
+	digest, err := b.initializer.Init(ctx, cacheDir, path, source.Reference, source.Digest)
+	if err != nil {
+		return types.BaseSource{}, err
+	}
+	source.Digest = digest
+	return source, nil
+}
+
+// verifyLocked fails if the source of the existing base image changed since it was initialized.
+func (b *Builder) verifyLocked(ctx context.Context, buildKey types.BuildKey) error {
+	buildID, err := b.storage.BuildID(ctx, buildKey)
+	switch {
+	case err == nil:
+	case errors.Is(err, types.ErrImageDoesNotExist):
+		return nil
+	default:
+		return err
+	}
+
+	info, err := b.storage.Info(ctx, buildID)
+	if err != nil {
+		return err
+	}
+	if info.Base == nil || info.Base.Digest == "" {
+		return nil
+	}
+
+	digest, err := b.initializer.Digest(ctx, info.Base.Reference)
+	if err != nil {
+		return err
+	}
+	if digest != "" && digest != info.Base.Digest {
+		return errors.Errorf("base image %s changed, it was initialized from %s but now it is %s",
+			info.Base.Reference, info.Base.Digest, digest)
+	}
+	return nil
+}
+
+// fromKey returns build key of the image referenced by FROM command.
+// Base images referenced using scheme are registered, so they are initialized from the right source.
+func (b *Builder) fromKey(cmd *description.FromCommand) (types.BuildKey, error) {
+	if cmd.Base == "" {
+		return cmd.BuildKey, nil
+	}
+
+	buildKey, err := b.initializer.BuildKey(cmd.Base, cmd.Digest)
+	if err != nil {
+		return types.BuildKey{}, err
+	}
+	source := types.BaseSource{Reference: cmd.Base, Digest: cmd.Digest}
+	if existing, exists := b.bases[buildKey]; exists && existing != source {
+		return types.BuildKey{}, errors.Errorf("base images %s and %s are both stored as %s", existing.Reference,
+			cmd.Base, buildKey)
+	}
+	b.bases[buildKey] = source
+	return buildKey, nil
+}
+
+// builderMounts returns mounts available to commands executed inside the build.
+func builderMounts(buildCache *cache.Cache) []wire.Mount {
+	return []wire.Mount{
+		{
+			Host:      ".",
+			Namespace: specDirMountpoint,
+			Writable:  true,
+		},
+		{
+			Host:      buildCache.Dir(),
+			Namespace: cacheMountpoint,
+			Writable:  true,
+		},
+	}
+}
+
+// removeMountpoints removes mountpoints created inside the build by builder mounts, so they don't land in the image.
+func removeMountpoints(path string, extra ...string) error {
+	for _, mountpoint := range append([]string{specDirMountpoint, cacheMountpoint}, extra...) {
+		if err := os.Remove(filepath.Join(path, mountpoint)); err != nil && !os.IsNotExist(err) {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
 }
 
 func (b *Builder) build(
 	ctx context.Context,
 	cacheDir string,
 	stack map[types.BuildKey]bool,
+	specFile string,
 	img *description.Descriptor,
 ) (retBuildID types.BuildID, retErr error) {
-	if !types.IsNameValid(img.Name()) {
-		return "", errors.Errorf("name %s is invalid", img.Name())
+	if err := img.Validate(); err != nil {
+		return "", err
 	}
 	tags := img.Tags()
 	if len(tags) == 0 {
@@ -104,9 +326,6 @@ func (b *Builder) build(
 	}
 	keys := make([]types.BuildKey, 0, len(tags))
 	for _, tag := range tags {
-		if !tag.IsValid() {
-			return "", errors.Errorf("tag %s is invalid", tag)
-		}
 		key := types.NewBuildKey(img.Name(), tag)
 		if stack[key] {
 			return "", errors.Errorf("loop in dependencies detected on image %s", key)
@@ -115,32 +334,93 @@ func (b *Builder) build(
 		keys = append(keys, key)
 	}
 
+	// Files included by all the stages are taken, before stages are split.
+	includes := specIncludes(specFile, img.Commands())
+	img, stageKeys, err := b.registerStages(img, tags[0])
+	if err != nil {
+		return "", err
+	}
+
 	buildID := types.NewBuildID(types.BuildTypeImage)
 
+	buildStart := time.Now().UTC()
+	b.reporter.Report(progress.Event{
+		Type:      progress.EventBuildStart,
+		Time:      buildStart,
+		BuildID:   buildID,
+		BuildKeys: keys,
+	})
+
+	buildLog, err := buildlog.Create(cacheDir, buildID)
+	if err != nil {
+		return "", err
+	}
+	var info types.BuildInfo
+	defer func() {
+		if retErr != nil {
+			retErr = errors.WithMessagef(retErr, "build %s failed", buildID)
+		}
+		if err := buildLog.Close(retErr); err != nil && retErr == nil {
+			retErr = err
+		}
+
+		event := progress.Event{
+			Type:     progress.EventBuildEnd,
+			Time:     time.Now().UTC(),
+			BuildID:  buildID,
+			Duration: time.Since(buildStart),
+		}
+		if retErr != nil {
+			event.Error = retErr.Error()
+		} else {
+			event.Info = &info
+		}
+		b.reporter.Report(event)
+	}()
+
+	// Stages are dropped once all the clones of them, used to copy files, are dropped.
+	defer func() {
+		if err := b.dropStages(ctx, cacheDir, buildID, stageKeys); err != nil && retErr == nil {
+			retErr = err
+		}
+	}()
+
 	var imgFinalize storage.FinalizeFn
 	var path string
-	defer func() {
+	var extraMountpoints []string
+	var finalized, finalizeFailed bool
+	// finalize removes mountpoints and finalizes the build, so it might be tagged.
+	finalize := func() error {
+		finalized = true
 		if path != "" {
-			if err := os.Remove(filepath.Join(path, ".specdir")); err != nil && !os.IsNotExist(err) {
-				if retErr == nil {
-					retErr = err
-				}
-				return
+			if err := removeMountpoints(path, extraMountpoints...); err != nil {
+				return err
 			}
 		}
 		if imgFinalize != nil {
-			if err := imgFinalize(); err != nil {
-				if retErr == nil {
-					retErr = err
-				}
+			return imgFinalize()
+		}
+		return nil
+	}
+	defer func() {
+		if retErr == nil || finalizeFailed {
+			return
+		}
+		if !finalized {
+			if err := finalize(); err != nil {
 				return
 			}
 		}
-		if retErr != nil {
-			if err := b.storage.Drop(ctx, buildID); err != nil && !errors.Is(err, types.ErrImageDoesNotExist) {
-				retErr = err
+		if b.keepFailed && imgFinalize != nil {
+			tag := types.Tag(failedTagPrefix + time.Now().Format("20060102-150405"))
+			if err := b.storage.Tag(ctx, buildID, tag); err == nil {
+				retErr = errors.WithMessagef(retErr, "failed build kept as %s",
+					types.NewBuildKey(img.Name(), tag))
+				return
 			}
-			return
+		}
+		if err := b.storage.Drop(ctx, buildID); err != nil && !errors.Is(err, types.ErrImageDoesNotExist) {
+			retErr = err
 		}
 	}()
 
@@ -156,7 +436,17 @@ func (b *Builder) build(
 			return "", err
 		}
 
-		if err := b.initialize(ctx, cacheDir, types.NewBuildKey(img.Name(), tags[0]), path); err != nil {
+		execCtx, cancel := b.withDeadline(ctx)
+		defer cancel()
+
+		source, err := b.initialize(execCtx, cacheDir, types.NewBuildKey(img.Name(), tags[0]), path)
+		if err != nil {
+			return "", b.timeoutError(ctx, execCtx, err)
+		}
+		if err := b.storage.StoreManifest(ctx, types.ImageManifest{
+			BuildID: buildID,
+			Base:    &source,
+		}); err != nil {
 			return "", err
 		}
 	} else {
@@ -165,11 +455,15 @@ func (b *Builder) build(
 			return "", errors.New("first command must be FROM")
 		}
 
-		var err error
+		fromKey, err := b.fromKey(fromCommand)
+		if err != nil {
+			return "", err
+		}
+
 		var buildInfo types.BuildInfo
 		imgFinalize, path, buildInfo, err = b.clone(
 			ctx,
-			fromCommand.BuildKey,
+			fromKey,
 			cacheDir,
 			stack,
 			img,
@@ -179,53 +473,148 @@ func (b *Builder) build(
 			return "", err
 		}
 
-		err = isolator.Run(ctx, isolator.Config{
+		provenance, err := newProvenance(specFile, includes, fromKey, buildInfo.BuildID, b.stages[fromKey])
+		if err != nil {
+			return "", err
+		}
+		provenance.Commands = specCommands(fromKey, commands)
+
+		buildCache := cache.New(cacheDir)
+		if err := os.MkdirAll(buildCache.Dir(), 0o700); err != nil {
+			return "", errors.WithStack(err)
+		}
+
+		secrets, err := newSecretStore(b.secrets)
+		if err != nil {
+			return "", err
+		}
+
+		copySources, copyMounts, dropCopySources, err := b.prepareCopySources(ctx, cacheDir, stack, commands[1:])
+		if err != nil {
+			return "", err
+		}
+		defer func() {
+			if err := dropCopySources(); err != nil && retErr == nil {
+				retErr = err
+			}
+		}()
+		for i := len(copyMounts) - 1; i >= 0; i-- {
+			extraMountpoints = append(extraMountpoints, copyMounts[i].Namespace)
+		}
+		if len(copyMounts) > 0 {
+			extraMountpoints = append(extraMountpoints, stagesMountpoint)
+		}
+
+		group, err := newBuildGroup(buildID, b.limits, commands)
+		if err != nil {
+			return "", err
+		}
+		if group != nil {
+			defer func() {
+				if err := group.Close(); err != nil && retErr == nil {
+					retErr = err
+				}
+			}()
+		}
+
+		execCtx, cancel := b.withDeadline(ctx)
+		defer cancel()
+
+		err = isolator.Run(execCtx, isolator.Config{
 			Dir: path,
 			Types: []interface{}{
 				wire.Result{},
 				wire.Log{},
+				runner.Started{},
 			},
 			Executor: wire.Config{
 				ConfigureSystem: true,
 				UseHostNetwork:  true,
-				Mounts: []wire.Mount{
-					{
-						Host:      ".",
-						Namespace: "/.specdir",
-						Writable:  true,
-					},
-				},
+				Mounts:          append(builderMounts(buildCache), copyMounts...),
 			},
 		}, func(ctx context.Context, incoming <-chan interface{}, outgoing chan<- interface{}) error {
-			build := newImageBuild(buildInfo, incoming, outgoing)
-			for _, cmd := range commands[1:] {
+			build := newImageBuild(buildInfo, buildID, path, b.network, b.limits, group, buildCache, secrets,
+				copySources, buildLog, b.reporter, incoming, outgoing)
+			build.manifest.Provenance = provenance
+			if err := build.attach(ctx); err != nil {
+				return err
+			}
+			var tests []int
+			for i, cmd := range commands[1:] {
 				select {
 				case <-ctx.Done():
 					return errors.WithStack(ctx.Err())
 				default:
 				}
 
-				if err := cmd.Execute(ctx, build); err != nil {
+				// Tests are executed once the image is complete.
+				if _, ok := cmd.(*description.TestCommand); ok {
+					tests = append(tests, i+1)
+					continue
+				}
+				if err := build.execute(ctx, i+1, cmd); err != nil {
 					return err
 				}
 			}
 
+			// All the tests are executed, even if some of them fail, to report all the problems at once.
+			var testErr error
+			for _, step := range tests {
+				if err := ctx.Err(); err != nil {
+					return errors.WithStack(err)
+				}
+				if err := build.execute(ctx, step, commands[step]); err != nil && testErr == nil {
+					testErr = err
+				}
+			}
+			if testErr == nil {
+				testErr = build.verifyBoot()
+			}
+
+			if err := build.usage(); err != nil {
+				return err
+			}
+
 			build.manifest.BuildID = buildID
-			return b.storage.StoreManifest(ctx, build.manifest)
+			if err := b.storage.StoreManifest(ctx, build.manifest); err != nil {
+				return err
+			}
+			return testErr
 		})
 		if err != nil {
-			return "", err
+			return "", b.timeoutError(ctx, execCtx, err)
 		}
 	}
 
+	if err := finalize(); err != nil {
+		finalizeFailed = true
+		return "", err
+	}
+	b.reporter.Report(progress.Event{
+		Type:    progress.EventSnapshot,
+		Time:    time.Now().UTC(),
+		BuildID: buildID,
+	})
+
 	for _, key := range keys {
 		if err := b.storage.Tag(ctx, buildID, key.Tag); err != nil {
 			return "", err
 		}
 	}
+	b.reporter.Report(progress.Event{
+		Type:      progress.EventTag,
+		Time:      time.Now().UTC(),
+		BuildID:   buildID,
+		BuildKeys: keys,
+	})
 	for _, key := range keys {
 		b.readyBuilds[key] = true
 	}
+
+	info, err = b.storage.Info(ctx, buildID)
+	if err != nil {
+		return "", err
+	}
 	return buildID, nil
 }
 
@@ -237,29 +626,77 @@ func (b *Builder) clone(
 	img *description.Descriptor,
 	buildID types.BuildID,
 ) (storage.FinalizeFn, string, types.BuildInfo, error) {
+	srcBuildID, err := b.resolve(ctx, srcBuildKey, cacheDir, stack)
+	if err != nil {
+		return nil, "", types.BuildInfo{}, err
+	}
+
+	imgFinalize, path, err := b.storage.Clone(ctx, srcBuildID, img.Name(), buildID)
+	if err != nil {
+		return nil, "", types.BuildInfo{}, err
+	}
+
+	buildInfo, err := b.storage.Info(ctx, srcBuildID)
+	if err != nil {
+		return imgFinalize, "", types.BuildInfo{}, err
+	}
+
+	return imgFinalize, path, buildInfo, nil
+}
+
+// resolve returns ID of the cloneable build identified by build key, building it if it doesn't exist.
+func (b *Builder) resolve(
+	ctx context.Context,
+	srcBuildKey types.BuildKey,
+	cacheDir string,
+	stack map[types.BuildKey]bool,
+) (types.BuildID, error) {
 	if !types.IsNameValid(srcBuildKey.Name) {
-		return nil, "", types.BuildInfo{}, errors.Errorf("name %s is invalid", srcBuildKey.Name)
+		return "", errors.Errorf("name %s is invalid", srcBuildKey.Name)
 	}
 	if !srcBuildKey.Tag.IsValid() {
-		return nil, "", types.BuildInfo{}, errors.Errorf("tag %s is invalid", srcBuildKey.Tag)
+		return "", errors.Errorf("tag %s is invalid", srcBuildKey.Tag)
 	}
 
-	// Try to clone existing image.
+	if b.locked && !b.readyBuilds[srcBuildKey] {
+		if err := b.verifyLocked(ctx, srcBuildKey); err != nil {
+			return "", err
+		}
+	}
+
+	// Try to clone existing image. Build stages are always rebuilt together with the image depending on them.
 	err := types.ErrImageDoesNotExist
 	var srcBuildID types.BuildID
-	if !b.rebuild || b.readyBuilds[srcBuildKey] {
+	if (!b.rebuild && !b.stages[srcBuildKey]) || b.readyBuilds[srcBuildKey] {
 		srcBuildID, err = b.storage.BuildID(ctx, srcBuildKey)
 	}
 
+	rebuilt := false
+	if err == nil && b.ifStale && !b.readyBuilds[srcBuildKey] {
+		existingBuildID := srcBuildID
+		srcBuildID, err = b.rebuildIfStale(ctx, cacheDir, stack, srcBuildKey, srcBuildID)
+		if err != nil {
+			return "", err
+		}
+		rebuilt = srcBuildID != existingBuildID
+	}
+
 	switch {
+	case rebuilt:
 	case err == nil:
+		b.reporter.Report(progress.Event{
+			Type:      progress.EventCacheHit,
+			Time:      time.Now().UTC(),
+			BuildID:   srcBuildID,
+			BuildKeys: []types.BuildKey{srcBuildKey},
+		})
 	case errors.Is(err, types.ErrImageDoesNotExist):
 		// If image does not exist try to build it from file in the current directory but only if tag is a default one.
-		if srcBuildKey.Tag == description.DefaultTag {
+		if srcBuildKey.Tag == description.DefaultTag && !b.stages[srcBuildKey] {
 			_, err = b.buildFromFile(ctx, cacheDir, stack, srcBuildKey.Name, srcBuildKey.Name, description.DefaultTag)
 		}
 	default:
-		return nil, "", types.BuildInfo{}, err
+		return "", err
 	}
 
 	switch {
@@ -267,89 +704,231 @@ func (b *Builder) clone(
 	case errors.Is(err, types.ErrImageDoesNotExist):
 		if baseImage := b.repo.Retrieve(srcBuildKey); baseImage != nil {
 			// If spec file does not exist, try building from repository.
-			_, err = b.build(ctx, cacheDir, stack, baseImage)
+			_, err = b.build(ctx, cacheDir, stack, "", baseImage)
+		} else if specFile := b.repo.SpecFile(srcBuildKey); specFile != "" && !b.stages[srcBuildKey] {
+			// Spec file found in spec search path.
+			_, err = b.buildFromSpecDir(ctx, cacheDir, stack, specFile, srcBuildKey.Name, srcBuildKey.Tag)
 		} else {
-			_, err = b.build(ctx, cacheDir, stack, description.Describe(srcBuildKey.Name, types.Tags{srcBuildKey.Tag}))
+			_, err = b.build(ctx, cacheDir, stack, "", description.Describe(srcBuildKey.Name,
+				types.Tags{srcBuildKey.Tag}))
 		}
 	default:
-		return nil, "", types.BuildInfo{}, err
+		return "", err
 	}
 
 	if err != nil {
-		return nil, "", types.BuildInfo{}, err
+		return "", err
 	}
 
 	if !srcBuildID.IsValid() {
 		srcBuildID, err = b.storage.BuildID(ctx, srcBuildKey)
 		if err != nil {
-			return nil, "", types.BuildInfo{}, err
+			return "", err
 		}
 	}
 	if !srcBuildID.Type().Properties().Cloneable {
-		return nil, "", types.BuildInfo{}, errors.Errorf("build %s is not cloneable", srcBuildKey)
-	}
-
-	imgFinalize, path, err := b.storage.Clone(ctx, srcBuildID, img.Name(), buildID)
-	if err != nil {
-		return nil, "", types.BuildInfo{}, err
-	}
-
-	buildInfo, err := b.storage.Info(ctx, srcBuildID)
-	if err != nil {
-		return imgFinalize, "", types.BuildInfo{}, err
-	}
-
-	if err != nil {
-		return imgFinalize, "", types.BuildInfo{}, err
+		return "", errors.Errorf("build %s is not cloneable", srcBuildKey)
 	}
-
-	return imgFinalize, path, buildInfo, nil
+	return srcBuildID, nil
 }
 
 var _ description.ImageBuild = &imageBuild{}
 
-func newImageBuild(buildInfo types.BuildInfo, incoming <-chan interface{}, outgoing chan<- interface{}) *imageBuild {
+func newImageBuild(
+	buildInfo types.BuildInfo,
+	buildID types.BuildID,
+	path string,
+	network description.Network,
+	limits types.Limits,
+	group *cgroup.Group,
+	buildCache *cache.Cache,
+	secrets *secretStore,
+	copySources map[string]string,
+	buildLog *buildlog.Writer,
+	reporter progress.Reporter,
+	incoming <-chan interface{},
+	outgoing chan<- interface{},
+) *imageBuild {
 	return &imageBuild{
+		buildID:  buildID,
+		path:     path,
+		workDir:  "/",
+		shell:    defaultShell,
+		network:  network,
+		limits:   limits,
+		group:    group,
+		cache:    buildCache,
+		secrets:  secrets,
+		copySrc:  copySources,
+		log:      buildLog,
+		reporter: reporter,
 		incoming: incoming,
 		outgoing: outgoing,
 		manifest: types.ImageManifest{
 			BasedOn: buildInfo.BuildID,
 			Params:  buildInfo.Params,
+			Labels:  buildInfo.Labels.Clone(),
 		},
 	}
 }
 
+var defaultShell = []string{"/bin/sh", "-c"}
+
 type imageBuild struct {
+	buildID  types.BuildID
+	path     string
+	workDir  string
+	shell    []string
+	network  description.Network
+	limits   types.Limits
+	group    *cgroup.Group
+	cache    *cache.Cache
+	secrets  *secretStore
+	copySrc  map[string]string
+	log      *buildlog.Writer
+	reporter progress.Reporter
+	step     int
+	user     *runner.User
 	incoming <-chan interface{}
 	outgoing chan<- interface{}
 	manifest types.ImageManifest
 }
 
+// execute executes build step.
+func (b *imageBuild) execute(ctx context.Context, step int, cmd description.Command) error {
+	b.step = step
+	stepStart := time.Now().UTC()
+
+	b.reporter.Report(progress.Event{
+		Type:    progress.EventStepStart,
+		Time:    stepStart,
+		BuildID: b.buildID,
+		Step:    step,
+		Source:  cmd.Source().String(),
+		Command: fmt.Sprint(cmd),
+	})
+	if err := b.log.Step(step, cmd.Source().String(), fmt.Sprint(cmd)); err != nil {
+		return err
+	}
+
+	err := cmd.Execute(ctx, b)
+	if err2 := b.log.StepEnd(err); err2 != nil && err == nil {
+		err = err2
+	}
+
+	event := progress.Event{
+		Type:     progress.EventStepEnd,
+		Time:     time.Now().UTC(),
+		BuildID:  b.buildID,
+		Step:     step,
+		Duration: time.Since(stepStart),
+	}
+	if b.manifest.Provenance != nil {
+		b.manifest.Provenance.Steps = append(b.manifest.Provenance.Steps, types.Step{
+			Step:     step,
+			Source:   cmd.Source().String(),
+			Command:  fmt.Sprint(cmd),
+			Duration: event.Duration,
+		})
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	b.reporter.Report(event)
+
+	if err != nil {
+		return errors.WithMessagef(err, "step %d (%s) failed", step, cmd.Source())
+	}
+	return nil
+}
+
 // Params sets kernel params for image.
 func (b *imageBuild) Params(cmd *description.ParamsCommand) {
 	b.manifest.Params = append(b.manifest.Params, cmd.Params...)
 }
 
 // Run is a handler for RUN.
-func (b *imageBuild) Run(ctx context.Context, cmd *description.RunCommand) error {
+func (b *imageBuild) Run(ctx context.Context, cmd *description.RunCommand) (retErr error) {
+	var mounts []runner.Mount
+	var secrets []runner.Secret
+	for _, m := range cmd.Mounts {
+		switch m.Type {
+		case description.MountTypeCache:
+			unlock, err := b.cache.Lock(m.ID, m.Sharing == description.SharingLocked)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if err := unlock(); err != nil && retErr == nil {
+					retErr = err
+				}
+			}()
+
+			mounts = append(mounts, runner.Mount{
+				Source:   filepath.Join(cacheMountpoint, m.ID),
+				Target:   m.Target,
+				Writable: true,
+			})
+		case description.MountTypeSecret:
+			secret, err := b.secrets.Secret(m, b.user)
+			if err != nil {
+				return err
+			}
+			secrets = append(secrets, secret)
+		default:
+			return errors.Errorf("unsupported mount type '%s'", m.Type)
+		}
+	}
+
+	if b.group != nil {
+		if err := b.group.SetLimits(b.limits.Merge(cmd.Limits)); err != nil {
+			return err
+		}
+	}
+
+	network := cmd.Network
+	if network == description.NetworkDefault {
+		network = b.network
+	}
+
+	args := cmd.Args
+	if len(args) == 0 {
+		args = make([]string, 0, len(b.shell)+1)
+		args = append(args, b.shell...)
+		args = append(args, cmd.Command)
+	}
+
 	select {
 	case <-ctx.Done():
 		return errors.WithStack(ctx.Err())
-	case b.outgoing <- wire.Execute{Command: cmd.Command}:
+	case b.outgoing <- runner.Execute{
+		Args:           args,
+		WorkDir:        b.workDir,
+		User:           b.user,
+		IsolateNetwork: network == description.NetworkNone,
+		Mounts:         mounts,
+		Secrets:        secrets,
+		Timeout:        cmd.Timeout,
+	}:
 	}
 
 	for content := range b.incoming {
 		switch m := content.(type) {
 		case wire.Log:
-			if _, err := os.Stderr.Write(m.Content); err != nil {
-				return err
-			}
-			if _, err := os.Stderr.Write([]byte{'\n'}); err != nil {
+			content := b.secrets.Mask(m.Content)
+			if err := b.log.Log(m.Time, content); err != nil {
 				return err
 			}
+			b.reporter.Report(progress.Event{
+				Type:    progress.EventLog,
+				Time:    m.Time,
+				BuildID: b.buildID,
+				Step:    b.step,
+				Content: string(content),
+			})
 		case wire.Result:
 			if m.Error != "" {
-				return errors.Errorf("command failed: %s", m.Error)
+				return errors.Errorf("command failed: %s", b.secrets.Mask([]byte(m.Error)))
 			}
 			return nil
 		default:
@@ -360,7 +939,130 @@ func (b *imageBuild) Run(ctx context.Context, cmd *description.RunCommand) error
 	return errors.WithStack(ctx.Err())
 }
 
+// Test is a handler for TEST.
+func (b *imageBuild) Test(ctx context.Context, cmd *description.TestCommand) error {
+	start := time.Now()
+	err := b.Run(ctx, &description.RunCommand{
+		Command: cmd.Command,
+		Args:    cmd.Args,
+	})
+
+	result := types.TestResult{
+		Test:     cmd.String(),
+		Passed:   err == nil,
+		Duration: time.Since(start),
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	b.manifest.Tests = append(b.manifest.Tests, result)
+	return err
+}
+
+// verifyBoot verifies that bootable image contains kernel and initramfs required to boot it.
+func (b *imageBuild) verifyBoot() error {
+	if len(b.manifest.Boots) == 0 {
+		return nil
+	}
+	for _, file := range []string{"vmlinuz", "initramfs.img"} {
+		// Symlinks are resolved inside the image, as they are resolved by the boot loader.
+		path, err := ResolveInRoot(b.path, filepath.Join("/boot", file))
+		if err != nil {
+			return err
+		}
+		if info, err := os.Stat(path); err != nil || !info.Mode().IsRegular() {
+			return errors.Errorf("image defines boot options but /boot/%s is missing", file)
+		}
+	}
+	return nil
+}
+
+// Label sets label of an image, overriding the one inherited from parent.
+func (b *imageBuild) Label(cmd *description.LabelCommand) {
+	if b.manifest.Labels == nil {
+		b.manifest.Labels = types.Labels{}
+	}
+	b.manifest.Labels[cmd.Key] = cmd.Value
+}
+
 // Boot sets boot option for an image.
 func (b *imageBuild) Boot(cmd *description.BootCommand) {
 	b.manifest.Boots = append(b.manifest.Boots, types.Boot{Title: cmd.Title, Params: cmd.Params})
 }
+
+// Workdir sets working directory for subsequent commands.
+func (b *imageBuild) Workdir(cmd *description.WorkdirCommand) {
+	if filepath.IsAbs(cmd.Path) {
+		b.workDir = filepath.Clean(cmd.Path)
+		return
+	}
+	b.workDir = filepath.Join(b.workDir, cmd.Path)
+}
+
+// User sets user executing subsequent commands.
+func (b *imageBuild) User(cmd *description.UserCommand) error {
+	user, err := resolveUser(b.path, cmd.User)
+	if err != nil {
+		return err
+	}
+	if user.UID == 0 && user.GID == 0 && len(user.Groups) == 0 {
+		user = nil
+	}
+	b.user = user
+	return nil
+}
+
+// Copy copies files from the build stage, image or the directory containing spec file.
+func (b *imageBuild) Copy(ctx context.Context, cmd *description.CopyCommand) error {
+	root := specDirMountpoint
+	if cmd.From != "" {
+		var exists bool
+		root, exists = b.copySrc[cmd.From]
+		if !exists {
+			return errors.Errorf("source %s has not been prepared", cmd.From)
+		}
+	}
+
+	sources := make([]string, 0, len(cmd.Sources))
+	for _, src := range cmd.Sources {
+		// Source paths are always relative to the root, so they can't go outside of it.
+		sources = append(sources, filepath.Join(root, filepath.Clean("/"+src)))
+	}
+	dest := cmd.Dest
+	if !filepath.IsAbs(dest) {
+		dest = filepath.Join(b.workDir, dest)
+		if strings.HasSuffix(cmd.Dest, "/") {
+			dest += "/"
+		}
+	}
+
+	select {
+	case <-ctx.Done():
+		return errors.WithStack(ctx.Err())
+	case b.outgoing <- runner.Copy{
+		Root:              root,
+		Sources:           sources,
+		Dest:              dest,
+		PreserveOwnership: cmd.From != "",
+	}:
+	}
+
+	for content := range b.incoming {
+		switch m := content.(type) {
+		case wire.Result:
+			if m.Error != "" {
+				return errors.Errorf("copying failed: %s", m.Error)
+			}
+			return nil
+		default:
+			return errors.New("unexpected message received")
+		}
+	}
+
+	return errors.WithStack(ctx.Err())
+}
+
+// Shell sets shell used to execute subsequent commands.
+func (b *imageBuild) Shell(cmd *description.ShellCommand) {
+	b.shell = cmd.Shell
+}
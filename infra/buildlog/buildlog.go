@@ -0,0 +1,257 @@
+package buildlog
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/outofforest/osman/infra/types"
+)
+
+// RecordType is the type of log record.
+type RecordType string
+
+const (
+	// RecordStep is stored when build step starts.
+	RecordStep RecordType = "step"
+
+	// RecordLog is the line printed by the command.
+	RecordLog RecordType = "log"
+
+	// RecordStepEnd is stored when build step finishes.
+	RecordStepEnd RecordType = "stepEnd"
+
+	// RecordEnd is stored when build finishes.
+	RecordEnd RecordType = "end"
+)
+
+const pollInterval = 500 * time.Millisecond
+
+// Record is a single entry of build log.
+type Record struct {
+	Type     RecordType
+	Time     time.Time
+	Step     int           `json:",omitempty"`
+	Source   string        `json:",omitempty"`
+	Command  string        `json:",omitempty"`
+	Content  string        `json:",omitempty"`
+	Duration time.Duration `json:",omitempty"`
+	Error    string        `json:",omitempty"`
+}
+
+// Dir returns directory where build logs are stored.
+func Dir(cacheDir string) string {
+	return filepath.Join(cacheDir, "logs")
+}
+
+func file(cacheDir string, buildID types.BuildID) string {
+	return filepath.Join(Dir(cacheDir), string(buildID)+".log")
+}
+
+// Create creates log file for the build. File is locked until writer is closed, so readers know
+// if build is still running.
+func Create(cacheDir string, buildID types.BuildID) (*Writer, error) {
+	if err := os.MkdirAll(Dir(cacheDir), 0o700); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	f, err := os.OpenFile(file(cacheDir, buildID), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		_ = f.Close()
+		return nil, errors.WithStack(err)
+	}
+	return &Writer{
+		file:    f,
+		encoder: json.NewEncoder(f),
+	}, nil
+}
+
+// Writer stores build log records.
+type Writer struct {
+	mu        sync.Mutex
+	file      *os.File
+	encoder   *json.Encoder
+	step      int
+	stepStart time.Time
+}
+
+// Step stores the beginning of the build step.
+func (w *Writer) Step(step int, source, command string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.step = step
+	w.stepStart = time.Now().UTC()
+	return w.write(Record{
+		Type:    RecordStep,
+		Time:    w.stepStart,
+		Step:    step,
+		Source:  source,
+		Command: command,
+	})
+}
+
+// Log stores the line printed by the current step.
+func (w *Writer) Log(t time.Time, content []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.write(Record{
+		Type:    RecordLog,
+		Time:    t,
+		Step:    w.step,
+		Content: string(content),
+	})
+}
+
+// StepEnd stores the result of the current step.
+func (w *Writer) StepEnd(err error) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	now := time.Now().UTC()
+	return w.write(Record{
+		Type:     RecordStepEnd,
+		Time:     now,
+		Step:     w.step,
+		Duration: now.Sub(w.stepStart),
+		Error:    errorString(err),
+	})
+}
+
+// Close stores the result of the build and closes the log file.
+func (w *Writer) Close(err error) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.write(Record{
+		Type:  RecordEnd,
+		Time:  time.Now().UTC(),
+		Error: errorString(err),
+	}); err != nil {
+		_ = w.file.Close()
+		return err
+	}
+	return errors.WithStack(w.file.Close())
+}
+
+func (w *Writer) write(record Record) error {
+	return errors.WithStack(w.encoder.Encode(record))
+}
+
+// Read reads build log records and passes them to the function. If step is greater than 0, only records of that
+// step are returned. If follow is true, function waits for new records until the build finishes or its process
+// holding the log is gone.
+func Read(
+	ctx context.Context,
+	cacheDir string,
+	buildID types.BuildID,
+	step int,
+	follow bool,
+	fn func(record Record) error,
+) error {
+	f, err := os.Open(file(cacheDir, buildID))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return errors.Errorf("logs of build %s do not exist", buildID)
+		}
+		return errors.WithStack(err)
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var line []byte
+	for {
+		chunk, err := r.ReadBytes('\n')
+		line = append(line, chunk...)
+		switch {
+		case err == nil:
+		case errors.Is(err, io.EOF):
+			if !follow {
+				return nil
+			}
+			running, err := isRunning(f)
+			if err != nil {
+				return err
+			}
+			if !running {
+				// Build died without storing the end record. Records written before writer went away are read
+				// once again before returning.
+				follow = false
+				continue
+			}
+			select {
+			case <-ctx.Done():
+				return errors.WithStack(ctx.Err())
+			case <-time.After(pollInterval):
+			}
+			continue
+		default:
+			return errors.WithStack(err)
+		}
+
+		var record Record
+		if err := json.Unmarshal(bytes.TrimSpace(line), &record); err != nil {
+			return errors.WithStack(fmt.Errorf("invalid log record: %w", err))
+		}
+		line = nil
+
+		if record.Type == RecordEnd {
+			return fn(record)
+		}
+		if step > 0 && record.Step != step {
+			continue
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+}
+
+// Remove removes log of the build.
+func Remove(cacheDir string, buildID types.BuildID) error {
+	if err := os.Remove(file(cacheDir, buildID)); err != nil && !os.IsNotExist(err) {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// isRunning returns true if log file still exists and is locked by the writer.
+func isRunning(f *os.File) (bool, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	if info.Sys().(*syscall.Stat_t).Nlink == 0 {
+		return false, nil
+	}
+
+	err = syscall.Flock(int(f.Fd()), syscall.LOCK_SH|syscall.LOCK_NB)
+	switch {
+	case err == nil:
+		return false, errors.WithStack(syscall.Flock(int(f.Fd()), syscall.LOCK_UN))
+	case errors.Is(err, syscall.EWOULDBLOCK):
+		return true, nil
+	default:
+		return false, errors.WithStack(err)
+	}
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
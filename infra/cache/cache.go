@@ -0,0 +1,211 @@
+package cache
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/outofforest/osman/infra/description"
+)
+
+// ErrCacheInUse is returned if cache being pruned is used by running build.
+var ErrCacheInUse = errors.New("cache is in use")
+
+const lockSuffix = ".lock"
+
+// New returns new manager of build caches stored inside cache directory.
+func New(cacheDir string) *Cache {
+	return &Cache{
+		dir: filepath.Join(cacheDir, "build-cache"),
+	}
+}
+
+// Cache manages persistent caches mounted into RUN commands.
+type Cache struct {
+	dir string
+}
+
+// Dir returns directory where caches are stored.
+func (c *Cache) Dir() string {
+	return c.dir
+}
+
+// Lock locks the cache and returns the function releasing the lock. If exclusive is true no other build
+// may use the cache at the same time.
+func (c *Cache) Lock(id string, exclusive bool) (func() error, error) {
+	if !description.IsMountIDValid(id) {
+		return nil, errors.Errorf("cache id '%s' is invalid", id)
+	}
+	if err := os.MkdirAll(filepath.Join(c.dir, id), 0o755); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	f, err := lockFile(filepath.Join(c.dir, id+lockSuffix), how)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if err := os.Chtimes(f.Name(), now, now); err != nil {
+		_ = f.Close()
+		return nil, errors.WithStack(err)
+	}
+
+	return func() error {
+		return errors.WithStack(f.Close())
+	}, nil
+}
+
+// List returns information about existing caches.
+func (c *Cache) List() ([]Info, error) {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []Info{}, nil
+		}
+		return nil, errors.WithStack(err)
+	}
+
+	list := make([]Info, 0, len(entries))
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		info, err := c.info(e.Name())
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, info)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ID < list[j].ID
+	})
+	return list, nil
+}
+
+// Prune deletes caches. If no ID is provided all the caches are deleted.
+func (c *Cache) Prune(ids ...string) ([]PruneResult, error) {
+	if len(ids) == 0 {
+		list, err := c.List()
+		if err != nil {
+			return nil, err
+		}
+		for _, info := range list {
+			ids = append(ids, info.ID)
+		}
+	}
+
+	results := make([]PruneResult, 0, len(ids))
+	for _, id := range ids {
+		if !description.IsMountIDValid(id) {
+			results = append(results, PruneResult{ID: id, Result: errors.Errorf("cache id '%s' is invalid", id)})
+			continue
+		}
+		info, err := c.info(id)
+		if err != nil {
+			results = append(results, PruneResult{ID: id, Result: err})
+			continue
+		}
+		results = append(results, PruneResult{ID: id, Size: info.Size, Result: c.prune(id)})
+	}
+	return results, nil
+}
+
+func (c *Cache) prune(id string) error {
+	f, err := lockFile(filepath.Join(c.dir, id+lockSuffix), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err != nil {
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return errors.WithStack(fmt.Errorf("cache %s can't be pruned: %w", id, ErrCacheInUse))
+		}
+		return err
+	}
+	defer f.Close()
+
+	if err := os.RemoveAll(filepath.Join(c.dir, id)); err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(os.Remove(f.Name()))
+}
+
+func (c *Cache) info(id string) (Info, error) {
+	dir := filepath.Join(c.dir, id)
+	if _, err := os.Stat(dir); err != nil {
+		if os.IsNotExist(err) {
+			return Info{}, errors.Errorf("cache %s does not exist", id)
+		}
+		return Info{}, errors.WithStack(err)
+	}
+
+	info := Info{ID: id}
+	if stat, err := os.Stat(filepath.Join(c.dir, id+lockSuffix)); err == nil {
+		info.LastUsed = stat.ModTime()
+	}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			fi, err := d.Info()
+			if err != nil {
+				return err
+			}
+			info.Size += Size(fi.Size())
+		}
+		return nil
+	})
+	return info, errors.WithStack(err)
+}
+
+func lockFile(path string, how int) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err := syscall.Flock(int(f.Fd()), how); err != nil {
+		_ = f.Close()
+		return nil, errors.WithStack(err)
+	}
+	return f, nil
+}
+
+// Info stores information about cache.
+type Info struct {
+	ID       string
+	Size     Size
+	LastUsed time.Time
+}
+
+// PruneResult is the result of pruning the cache.
+type PruneResult struct {
+	ID     string
+	Size   Size
+	Result error
+}
+
+// Size is the size of cache in bytes.
+type Size int64
+
+// String returns human-readable representation of size.
+func (s Size) String() string {
+	const unit = 1024
+	if s < unit {
+		return fmt.Sprintf("%d B", s)
+	}
+	div, exp := int64(unit), 0
+	for n := int64(s) / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(s)/float64(div), strings.ToUpper("kmgtpe")[exp])
+}
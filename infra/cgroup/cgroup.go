@@ -0,0 +1,234 @@
+package cgroup
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/outofforest/osman/infra/types"
+)
+
+const (
+	root = "/sys/fs/cgroup"
+
+	// supervisorGroup is the leaf cgroup osman moves itself to, so controllers might be enabled in its cgroup.
+	supervisorGroup = "osman-supervisor"
+
+	// cpuPeriod is the period used to express CPU quota, in microseconds.
+	cpuPeriod = 100000
+)
+
+var controllers = []string{"cpu", "memory", "pids"}
+
+// ErrNotSupported is returned if unified cgroup hierarchy (cgroup v2) is not available.
+var ErrNotSupported = errors.New("cgroup v2 is not available on this host")
+
+// IsSupported returns true if unified cgroup hierarchy is mounted.
+func IsSupported() bool {
+	_, err := os.Stat(filepath.Join(root, "cgroup.controllers"))
+	return err == nil
+}
+
+// New creates cgroup for the build inside the cgroup delegated to osman, which is the one it is running in.
+// Controllers are enabled only inside that cgroup, so they must be delegated to it first,
+// e.g. by running osman using `systemd-run --scope -p Delegate=yes`.
+func New(buildID types.BuildID) (*Group, error) {
+	if !IsSupported() {
+		return nil, errors.WithStack(ErrNotSupported)
+	}
+
+	parentDir, err := delegatedDir()
+	if err != nil {
+		return nil, err
+	}
+	if err := enableControllers(parentDir); err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Join(parentDir, string(buildID))
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &Group{dir: dir}, nil
+}
+
+// delegatedDir returns directory of the cgroup osman is running in.
+func delegatedDir() (string, error) {
+	content, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		path, ok := strings.CutPrefix(line, "0::")
+		if !ok {
+			continue
+		}
+		if filepath.Base(path) == supervisorGroup {
+			path = filepath.Dir(path)
+		}
+		return filepath.Join(root, path), nil
+	}
+	return "", errors.WithStack(ErrNotSupported)
+}
+
+// enableControllers enables controllers in the delegated cgroup. Cgroup having controllers enabled can't contain
+// processes, so osman moves itself to the leaf cgroup first. Root cgroup is never modified.
+func enableControllers(dir string) error {
+	enabled, err := readList(filepath.Join(dir, "cgroup.subtree_control"))
+	if err != nil {
+		return err
+	}
+	var missing []string
+	for _, c := range controllers {
+		if !enabled[c] {
+			missing = append(missing, c)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	available, err := readList(filepath.Join(dir, "cgroup.controllers"))
+	if err != nil {
+		return err
+	}
+	for _, c := range missing {
+		if !available[c] || dir == root {
+			return errors.Errorf("controller %s is not delegated to cgroup %s", c, strings.TrimPrefix(dir, root))
+		}
+	}
+
+	supervisorDir := filepath.Join(dir, supervisorGroup)
+	if err := os.MkdirAll(supervisorDir, 0o755); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := os.WriteFile(filepath.Join(supervisorDir, "cgroup.procs"), []byte(strconv.Itoa(os.Getpid())),
+		0o644); err != nil {
+		return errors.Wrapf(err, "moving osman to cgroup %s failed", supervisorDir)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "cgroup.subtree_control"), []byte("+"+strings.Join(missing, " +")),
+		0o644); err != nil {
+		return errors.Wrapf(err, "enabling controllers in cgroup %s failed", dir)
+	}
+	return nil
+}
+
+func readList(file string) (map[string]bool, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	res := map[string]bool{}
+	for _, v := range strings.Fields(string(content)) {
+		res[v] = true
+	}
+	return res, nil
+}
+
+// Group is the cgroup limiting resources of the build.
+type Group struct {
+	dir string
+}
+
+// Add moves process to the group. Children started by the process later belong to the group too.
+func (g *Group) Add(pid int) error {
+	return g.write("cgroup.procs", strconv.Itoa(pid))
+}
+
+// SetLimits sets limits of the group. Zero value of the limit removes it.
+func (g *Group) SetLimits(limits types.Limits) error {
+	cpuMax := "max"
+	if limits.CPUs > 0 {
+		cpuMax = strconv.FormatUint(uint64(limits.CPUs*cpuPeriod), 10)
+	}
+	if err := g.write("cpu.max", fmt.Sprintf("%s %d", cpuMax, cpuPeriod)); err != nil {
+		return err
+	}
+
+	memoryMax := "max"
+	if limits.Memory > 0 {
+		memoryMax = strconv.FormatUint(limits.Memory, 10)
+	}
+	if err := g.write("memory.max", memoryMax); err != nil {
+		return err
+	}
+	// Build is not allowed to swap, otherwise memory limit would make it extremely slow instead of failing.
+	if limits.Memory > 0 {
+		if err := g.write("memory.swap.max", "0"); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	pidsMax := "max"
+	if limits.Pids > 0 {
+		pidsMax = strconv.FormatUint(limits.Pids, 10)
+	}
+	return g.write("pids.max", pidsMax)
+}
+
+// Usage returns peak resource usage of the group.
+func (g *Group) Usage() (types.Usage, error) {
+	var usage types.Usage
+
+	cpuStat, err := os.ReadFile(filepath.Join(g.dir, "cpu.stat"))
+	if err != nil {
+		return types.Usage{}, errors.WithStack(err)
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(cpuStat))
+	for scanner.Scan() {
+		key, value, _ := strings.Cut(scanner.Text(), " ")
+		if key == "usage_usec" {
+			usec, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return types.Usage{}, errors.WithStack(err)
+			}
+			usage.CPU = time.Duration(usec) * time.Microsecond
+		}
+	}
+
+	// Peak files are not available on older kernels.
+	if usage.MemoryPeak, err = g.readUint("memory.peak"); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return types.Usage{}, err
+	}
+	if usage.PidsPeak, err = g.readUint("pids.peak"); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return types.Usage{}, err
+	}
+	return usage, nil
+}
+
+// Close removes the group. Processes must exit before, so it waits a moment for them to be reaped.
+func (g *Group) Close() error {
+	var err error
+	for range 50 {
+		err = syscall.Rmdir(g.dir)
+		if err == nil || errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if !errors.Is(err, syscall.EBUSY) {
+			break
+		}
+		<-time.After(100 * time.Millisecond)
+	}
+	return errors.Wrapf(err, "removing cgroup %s failed", g.dir)
+}
+
+func (g *Group) write(file, value string) error {
+	return errors.WithStack(os.WriteFile(filepath.Join(g.dir, file), []byte(value), 0o644))
+}
+
+func (g *Group) readUint(file string) (uint64, error) {
+	content, err := os.ReadFile(filepath.Join(g.dir, file))
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	value, err := strconv.ParseUint(strings.TrimSpace(string(content)), 10, 64)
+	return value, errors.WithStack(err)
+}
@@ -2,8 +2,13 @@ package description
 
 import (
 	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/pkg/errors"
+	"github.com/ridge/must"
 
 	"github.com/outofforest/osman/infra/types"
 )
@@ -13,6 +18,12 @@ var (
 	_ Command = &ParamsCommand{}
 	_ Command = &RunCommand{}
 	_ Command = &BootCommand{}
+	_ Command = &WorkdirCommand{}
+	_ Command = &UserCommand{}
+	_ Command = &ShellCommand{}
+	_ Command = &CopyCommand{}
+	_ Command = &TestCommand{}
+	_ Command = &LabelCommand{}
 )
 
 // From returns handler for FROM command.
@@ -25,6 +36,42 @@ func From(buildKey types.BuildKey) Command {
 	}
 }
 
+// FromAs returns handler for FROM command starting named build stage.
+func FromAs(buildKey types.BuildKey, stage string) Command {
+	cmd := From(buildKey).(*FromCommand)
+	cmd.Stage = stage
+	return cmd
+}
+
+// FromOption configures FROM command.
+type FromOption func(cmd *FromCommand)
+
+// WithDigest pins the content of the base image to the digest, e.g. sha256:<hex>.
+func WithDigest(digest string) FromOption {
+	return func(cmd *FromCommand) {
+		cmd.Digest = digest
+	}
+}
+
+// FromBase returns handler for FROM command starting from base image referenced using scheme,
+// e.g. docker://fedora:40 or dir:/srv/rootfs.
+func FromBase(ref string, options ...FromOption) Command {
+	cmd := &FromCommand{
+		Base: ref,
+	}
+	for _, o := range options {
+		o(cmd)
+	}
+	return cmd
+}
+
+// FromBaseAs returns handler for FROM command starting named build stage from base image referenced using scheme.
+func FromBaseAs(ref string, stage string, options ...FromOption) Command {
+	cmd := FromBase(ref, options...).(*FromCommand)
+	cmd.Stage = stage
+	return cmd
+}
+
 // Params returns handler for PARAMS command.
 func Params(params ...string) Command {
 	return &ParamsCommand{
@@ -32,13 +79,88 @@ func Params(params ...string) Command {
 	}
 }
 
-// Run returns handler for RUN command.
-func Run(command string) Command {
-	return &RunCommand{
+// RunOption configures RUN command.
+type RunOption func(cmd *RunCommand)
+
+// WithNetwork sets network available to RUN command.
+func WithNetwork(network Network) RunOption {
+	return func(cmd *RunCommand) {
+		cmd.Network = network
+	}
+}
+
+// WithMount adds mount to RUN command.
+func WithMount(mount Mount) RunOption {
+	return func(cmd *RunCommand) {
+		cmd.Mounts = append(cmd.Mounts, mount)
+	}
+}
+
+// WithTimeout sets maximum time RUN command may run.
+func WithTimeout(timeout time.Duration) RunOption {
+	return func(cmd *RunCommand) {
+		cmd.Timeout = timeout
+	}
+}
+
+// WithLimits sets resource limits of RUN command, overriding build-level ones.
+func WithLimits(limits types.Limits) RunOption {
+	return func(cmd *RunCommand) {
+		cmd.Limits = cmd.Limits.Merge(limits)
+	}
+}
+
+// Run returns handler for RUN command executed by shell.
+func Run(command string, options ...RunOption) Command {
+	cmd := &RunCommand{
+		Command: command,
+	}
+	for _, o := range options {
+		o(cmd)
+	}
+	return cmd
+}
+
+// RunExec returns handler for RUN command executed directly, without shell.
+func RunExec(args []string, options ...RunOption) Command {
+	cmd := &RunCommand{
+		Args: args,
+	}
+	for _, o := range options {
+		o(cmd)
+	}
+	return cmd
+}
+
+// Test returns handler for TEST command executed by shell.
+func Test(command string) Command {
+	return &TestCommand{
 		Command: command,
 	}
 }
 
+// TestExec returns handler for TEST command executed directly, without shell.
+func TestExec(args []string) Command {
+	return &TestCommand{
+		Args: args,
+	}
+}
+
+// Label returns handler for LABEL command.
+func Label(key, value string) Command {
+	return &LabelCommand{
+		Key:   key,
+		Value: value,
+	}
+}
+
+// Shell returns handler for SHELL command.
+func Shell(shell ...string) Command {
+	return &ShellCommand{
+		Shell: shell,
+	}
+}
+
 // Boot returns handler for BOOT command.
 func Boot(title string, params []string) Command {
 	return &BootCommand{
@@ -47,9 +169,34 @@ func Boot(title string, params []string) Command {
 	}
 }
 
+// Workdir returns handler for WORKDIR command.
+func Workdir(path string) Command {
+	return &WorkdirCommand{
+		Path: path,
+	}
+}
+
+// User returns handler for USER command.
+func User(user string) Command {
+	return &UserCommand{
+		User: user,
+	}
+}
+
 // FromCommand executes FROM command.
 type FromCommand struct {
+	location
+
 	BuildKey types.BuildKey
+
+	// Base is the reference to base image in form <scheme>:<location>. If set, it is used instead of BuildKey.
+	Base string
+
+	// Digest pins the content of the base image.
+	Digest string
+
+	// Stage is the name of the build stage started by the command.
+	Stage string
 }
 
 // Execute executes build command.
@@ -57,8 +204,25 @@ func (cmd *FromCommand) Execute(ctx context.Context, build ImageBuild) error {
 	return errors.New("this should not be called")
 }
 
+// String returns string representation of the command.
+func (cmd *FromCommand) String() string {
+	from := cmd.BuildKey.String()
+	if cmd.Base != "" {
+		from = cmd.Base
+	}
+	if cmd.Digest != "" {
+		from = "--" + strings.Replace(cmd.Digest, ":", "=", 1) + " " + from
+	}
+	if cmd.Stage != "" {
+		return "FROM " + from + " AS " + cmd.Stage
+	}
+	return "FROM " + from
+}
+
 // ParamsCommand executes PARAMS command.
 type ParamsCommand struct {
+	location
+
 	Params []string
 }
 
@@ -68,9 +232,32 @@ func (cmd *ParamsCommand) Execute(ctx context.Context, build ImageBuild) error {
 	return nil
 }
 
+// String returns string representation of the command.
+func (cmd *ParamsCommand) String() string {
+	return "PARAMS " + strings.Join(cmd.Params, " ")
+}
+
 // RunCommand executes RUN command.
 type RunCommand struct {
+	location
+
+	// Command is executed by shell. It is used if Args is empty.
 	Command string
+
+	// Args is the command and its arguments executed without shell.
+	Args []string
+
+	// Network is the network available to the command.
+	Network Network
+
+	// Mounts is the list of mounts available to the command.
+	Mounts []Mount
+
+	// Timeout is the maximum time command may run. Zero means no timeout.
+	Timeout time.Duration
+
+	// Limits are the resource limits of the command.
+	Limits types.Limits
 }
 
 // Execute executes build command.
@@ -78,8 +265,37 @@ func (cmd *RunCommand) Execute(ctx context.Context, build ImageBuild) error {
 	return build.Run(ctx, cmd)
 }
 
+// String returns string representation of the command.
+func (cmd *RunCommand) String() string {
+	res := "RUN"
+	if cmd.Network != NetworkDefault {
+		res += " --network=" + string(cmd.Network)
+	}
+	for _, m := range cmd.Mounts {
+		res += " --mount=" + m.String()
+	}
+	if cmd.Timeout > 0 {
+		res += " --timeout=" + cmd.Timeout.String()
+	}
+	if cmd.Limits.CPUs > 0 {
+		res += " --cpus=" + strconv.FormatFloat(cmd.Limits.CPUs, 'f', -1, 64)
+	}
+	if cmd.Limits.Memory > 0 {
+		res += " --memory=" + strconv.FormatUint(cmd.Limits.Memory, 10)
+	}
+	if cmd.Limits.Pids > 0 {
+		res += " --pids=" + strconv.FormatUint(cmd.Limits.Pids, 10)
+	}
+	if len(cmd.Args) > 0 {
+		return res + " " + jsonArray(cmd.Args)
+	}
+	return res + " " + cmd.Command
+}
+
 // BootCommand executes BOOT command.
 type BootCommand struct {
+	location
+
 	Title  string
 	Params []string
 }
@@ -89,3 +305,160 @@ func (cmd *BootCommand) Execute(ctx context.Context, build ImageBuild) error {
 	build.Boot(cmd)
 	return nil
 }
+
+// String returns string representation of the command.
+func (cmd *BootCommand) String() string {
+	return "BOOT " + jsonArray(append([]string{cmd.Title}, cmd.Params...))
+}
+
+// WorkdirCommand executes WORKDIR command.
+type WorkdirCommand struct {
+	location
+
+	Path string
+}
+
+// Execute executes build command.
+func (cmd *WorkdirCommand) Execute(ctx context.Context, build ImageBuild) error {
+	build.Workdir(cmd)
+	return nil
+}
+
+// String returns string representation of the command.
+func (cmd *WorkdirCommand) String() string {
+	return "WORKDIR " + cmd.Path
+}
+
+// UserCommand executes USER command.
+type UserCommand struct {
+	location
+
+	User string
+}
+
+// Execute executes build command.
+func (cmd *UserCommand) Execute(ctx context.Context, build ImageBuild) error {
+	return build.User(cmd)
+}
+
+// String returns string representation of the command.
+func (cmd *UserCommand) String() string {
+	return "USER " + cmd.User
+}
+
+// ShellCommand executes SHELL command.
+type ShellCommand struct {
+	location
+
+	Shell []string
+}
+
+// Execute executes build command.
+func (cmd *ShellCommand) Execute(ctx context.Context, build ImageBuild) error {
+	build.Shell(cmd)
+	return nil
+}
+
+// String returns string representation of the command.
+func (cmd *ShellCommand) String() string {
+	return "SHELL " + jsonArray(cmd.Shell)
+}
+
+func jsonArray(values []string) string {
+	return string(must.Bytes(json.Marshal(values)))
+}
+
+// CopyOption configures COPY command.
+type CopyOption func(cmd *CopyCommand)
+
+// CopyFrom sets build stage or image files are copied from.
+func CopyFrom(from string) CopyOption {
+	return func(cmd *CopyCommand) {
+		cmd.From = from
+	}
+}
+
+// Copy returns handler for COPY command.
+func Copy(sources []string, dest string, options ...CopyOption) Command {
+	cmd := &CopyCommand{
+		Sources: sources,
+		Dest:    dest,
+	}
+	for _, o := range options {
+		o(cmd)
+	}
+	return cmd
+}
+
+// CopyCommand executes COPY command.
+type CopyCommand struct {
+	location
+
+	// From is the build stage or image files are copied from. If empty, files are copied from the directory
+	// containing spec file.
+	From string
+
+	// Sources are the copied paths. Glob patterns are accepted.
+	Sources []string
+
+	// Dest is the destination path in the image.
+	Dest string
+}
+
+// Execute executes build command.
+func (cmd *CopyCommand) Execute(ctx context.Context, build ImageBuild) error {
+	return build.Copy(ctx, cmd)
+}
+
+// String returns string representation of the command.
+func (cmd *CopyCommand) String() string {
+	res := "COPY"
+	if cmd.From != "" {
+		res += " --from=" + cmd.From
+	}
+	return res + " " + jsonArray(append(append([]string{}, cmd.Sources...), cmd.Dest))
+}
+
+// TestCommand executes TEST command.
+// Tests are executed after all the other commands of the image, failing test fails the build.
+type TestCommand struct {
+	location
+
+	// Command is executed by shell. It is used if Args is empty.
+	Command string
+
+	// Args is the command and its arguments executed without shell.
+	Args []string
+}
+
+// Execute executes build command.
+func (cmd *TestCommand) Execute(ctx context.Context, build ImageBuild) error {
+	return build.Test(ctx, cmd)
+}
+
+// String returns string representation of the command.
+func (cmd *TestCommand) String() string {
+	if len(cmd.Args) > 0 {
+		return "TEST " + jsonArray(cmd.Args)
+	}
+	return "TEST " + cmd.Command
+}
+
+// LabelCommand executes LABEL command.
+type LabelCommand struct {
+	location
+
+	Key   string
+	Value string
+}
+
+// Execute executes build command.
+func (cmd *LabelCommand) Execute(ctx context.Context, build ImageBuild) error {
+	build.Label(cmd)
+	return nil
+}
+
+// String returns string representation of the command.
+func (cmd *LabelCommand) String() string {
+	return "LABEL " + jsonArray([]string{cmd.Key + "=" + cmd.Value})
+}
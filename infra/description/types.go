@@ -2,6 +2,11 @@ package description
 
 import (
 	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/outofforest/osman/infra/types"
 )
@@ -9,10 +14,169 @@ import (
 // DefaultTag is used if user specified empty tag list.
 const DefaultTag types.Tag = "latest"
 
+// Network defines network access available to the command.
+type Network string
+
+const (
+	// NetworkDefault means that network configured for the build is used.
+	NetworkDefault Network = ""
+
+	// NetworkHost means that command uses network of the host.
+	NetworkHost Network = "host"
+
+	// NetworkNone means that command has no access to the network.
+	NetworkNone Network = "none"
+)
+
+// IsValid returns true if network is valid.
+func (n Network) IsValid() bool {
+	switch n {
+	case NetworkDefault, NetworkHost, NetworkNone:
+		return true
+	default:
+		return false
+	}
+}
+
+// MountType is the type of mount available to RUN command.
+type MountType string
+
+const (
+	// MountTypeCache is the persistent cache directory shared between builds.
+	MountTypeCache MountType = "cache"
+
+	// MountTypeSecret is the secret file provided to the build.
+	MountTypeSecret MountType = "secret"
+)
+
+// Sharing defines how cache is shared between concurrent builds.
+type Sharing string
+
+const (
+	// SharingShared allows concurrent builds to use the cache at the same time.
+	SharingShared Sharing = "shared"
+
+	// SharingLocked gives exclusive access to the cache to one build at a time.
+	SharingLocked Sharing = "locked"
+)
+
+// Mount defines mount available to RUN command.
+type Mount struct {
+	// Type is the type of mount.
+	Type MountType
+
+	// ID identifies the mount source.
+	ID string
+
+	// Target is the path where mount is mounted inside the image.
+	Target string
+
+	// Sharing defines how mount is shared between builds.
+	Sharing Sharing
+
+	// UID is the owner of the secret file. If nil, file is owned by the user executing the command.
+	UID *uint32
+
+	// GID is the group of the secret file. If nil, primary group of the user executing the command is used.
+	GID *uint32
+
+	// Mode is the permission mode of the secret file. Zero means 0400.
+	Mode os.FileMode
+}
+
+// CacheMount returns cache mount. If id is empty, it is derived from target.
+func CacheMount(id, target string, sharing Sharing) Mount {
+	if id == "" {
+		id = strings.ReplaceAll(strings.Trim(filepath.Clean(target), "/"), "/", "-")
+	}
+	if sharing == "" {
+		sharing = SharingShared
+	}
+	return Mount{
+		Type:    MountTypeCache,
+		ID:      id,
+		Target:  target,
+		Sharing: sharing,
+	}
+}
+
+// SecretMount returns secret mount. If target is empty, secret is mounted in /run/secrets.
+func SecretMount(id, target string) Mount {
+	if id == "" {
+		id = filepath.Base(target)
+	}
+	if target == "" {
+		target = filepath.Join("/run/secrets", id)
+	}
+	return Mount{
+		Type:   MountTypeSecret,
+		ID:     id,
+		Target: target,
+	}
+}
+
+// String returns string representation of mount.
+func (m Mount) String() string {
+	fields := []string{"type=" + string(m.Type), "id=" + m.ID, "target=" + m.Target}
+	if m.Sharing != "" {
+		fields = append(fields, "sharing="+string(m.Sharing))
+	}
+	if m.UID != nil {
+		fields = append(fields, "uid="+strconv.FormatUint(uint64(*m.UID), 10))
+	}
+	if m.GID != nil {
+		fields = append(fields, "gid="+strconv.FormatUint(uint64(*m.GID), 10))
+	}
+	if m.Mode != 0 {
+		fields = append(fields, fmt.Sprintf("mode=%04o", m.Mode))
+	}
+	return strings.Join(fields, ",")
+}
+
 // Command is implemented by commands available in SpecFile.
 type Command interface {
 	// Execute executes build command.
 	Execute(ctx context.Context, build ImageBuild) error
+
+	// Source returns the location where command is defined.
+	Source() Source
+
+	// Validate verifies that command is correctly defined.
+	Validate() error
+}
+
+// Source is the location where command is defined.
+type Source struct {
+	File string
+	Line int
+}
+
+// String returns string representation of source.
+func (s Source) String() string {
+	if s.File == "" {
+		return "<unknown>"
+	}
+	return fmt.Sprintf("%s:%d", s.File, s.Line)
+}
+
+// Locate sets the location where command is defined if it hasn't been set yet.
+func Locate(cmd Command, source Source) {
+	if l, ok := cmd.(interface{ setSource(source Source) }); ok && cmd.Source().File == "" {
+		l.setSource(source)
+	}
+}
+
+type location struct {
+	source Source
+}
+
+// Source returns the location where command is defined.
+func (l *location) Source() Source {
+	return l.source
+}
+
+func (l *location) setSource(source Source) {
+	l.source = source
 }
 
 // ImageBuild represents build in progress.
@@ -25,4 +189,22 @@ type ImageBuild interface {
 
 	// Boot executes BOOT command.
 	Boot(cmd *BootCommand)
+
+	// Workdir executes WORKDIR command.
+	Workdir(cmd *WorkdirCommand)
+
+	// User executes USER command.
+	User(cmd *UserCommand) error
+
+	// Shell executes SHELL command.
+	Shell(cmd *ShellCommand)
+
+	// Copy executes COPY command.
+	Copy(ctx context.Context, cmd *CopyCommand) error
+
+	// Label executes LABEL command.
+	Label(cmd *LabelCommand)
+
+	// Test executes TEST command.
+	Test(ctx context.Context, cmd *TestCommand) error
 }
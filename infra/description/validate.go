@@ -0,0 +1,218 @@
+package description
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/outofforest/osman/infra/types"
+)
+
+var (
+	mountIDRegExp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9\-_.]*$`)
+	digestRegExp  = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+)
+
+// IsMountIDValid returns true if id of cache or secret mount is valid.
+func IsMountIDValid(id string) bool {
+	return mountIDRegExp.MatchString(id)
+}
+
+// Validate verifies that image is correctly defined.
+func (d *Descriptor) Validate() error {
+	if !types.IsNameValid(d.name) {
+		return errors.Errorf("name %s is invalid", d.name)
+	}
+	for _, tag := range d.tags {
+		if !tag.IsValid() {
+			return errors.Errorf("tag %s of image %s is invalid", tag, d.name)
+		}
+	}
+	if len(d.commands) == 0 {
+		return nil
+	}
+
+	if _, ok := d.commands[0].(*FromCommand); !ok {
+		return errors.Errorf("first command of image %s must be FROM", d.name)
+	}
+	for i, cmd := range d.commands {
+		if cmd == nil {
+			return errors.Errorf("command %d of image %s is nil", i+1, d.name)
+		}
+		if err := cmd.Validate(); err != nil {
+			return errors.WithMessagef(err, "command %d (%s) of image %s is invalid", i+1, cmd.Source(), d.name)
+		}
+	}
+	return nil
+}
+
+// Validate verifies that mount is correctly defined.
+func (m Mount) Validate() error {
+	switch m.Type {
+	case MountTypeCache:
+		switch m.Sharing {
+		case SharingShared, SharingLocked:
+		default:
+			return errors.Errorf("invalid sharing mode '%s'", m.Sharing)
+		}
+		if m.UID != nil || m.GID != nil || m.Mode != 0 {
+			return errors.New("uid, gid and mode are supported only by secret mounts")
+		}
+	case MountTypeSecret:
+		if m.Sharing != "" {
+			return errors.New("sharing mode is not supported by secret mounts")
+		}
+		if m.Mode&^os.ModePerm != 0 {
+			return errors.Errorf("invalid mode '%o'", m.Mode)
+		}
+	default:
+		return errors.Errorf("unsupported mount type '%s'", m.Type)
+	}
+
+	if !filepath.IsAbs(m.Target) {
+		return errors.Errorf("mount target '%s' must be an absolute path", m.Target)
+	}
+	if !IsMountIDValid(m.ID) {
+		return errors.Errorf("mount id '%s' is invalid", m.ID)
+	}
+	return nil
+}
+
+// Validate verifies that command is correctly defined.
+func (cmd *FromCommand) Validate() error {
+	if cmd.Base != "" {
+		if _, _, err := types.ParseBaseReference(cmd.Base); err != nil {
+			return err
+		}
+		if cmd.Digest != "" && !digestRegExp.MatchString(cmd.Digest) {
+			return errors.Errorf("digest '%s' is invalid", cmd.Digest)
+		}
+	} else if cmd.Digest != "" {
+		return errors.New("digest may be pinned only for base images referenced using scheme")
+	} else if !cmd.BuildKey.IsValid() {
+		return errors.Errorf("image %s is invalid", cmd.BuildKey)
+	}
+	if cmd.Stage != "" && !types.IsNameValid(cmd.Stage) {
+		return errors.Errorf("stage name '%s' is invalid", cmd.Stage)
+	}
+	return nil
+}
+
+// Validate verifies that command is correctly defined.
+func (cmd *ParamsCommand) Validate() error {
+	if len(cmd.Params) == 0 {
+		return errors.New("no params passed")
+	}
+	return validateNotEmpty(cmd.Params)
+}
+
+// Validate verifies that command is correctly defined.
+func (cmd *RunCommand) Validate() error {
+	if err := validateCommand(cmd.Command, cmd.Args); err != nil {
+		return err
+	}
+	if !cmd.Network.IsValid() {
+		return errors.Errorf("invalid network '%s'", cmd.Network)
+	}
+	for _, m := range cmd.Mounts {
+		if err := m.Validate(); err != nil {
+			return err
+		}
+	}
+	if cmd.Timeout < 0 {
+		return errors.Errorf("timeout '%s' must not be negative", cmd.Timeout)
+	}
+	if cmd.Limits.CPUs < 0 {
+		return errors.Errorf("number of cpus '%g' must not be negative", cmd.Limits.CPUs)
+	}
+	return nil
+}
+
+// Validate verifies that command is correctly defined.
+func (cmd *BootCommand) Validate() error {
+	if cmd.Title == "" {
+		return errors.New("boot title is empty")
+	}
+	return validateNotEmpty(cmd.Params)
+}
+
+// Validate verifies that command is correctly defined.
+func (cmd *WorkdirCommand) Validate() error {
+	if cmd.Path == "" {
+		return errors.New("path is empty")
+	}
+	return nil
+}
+
+// Validate verifies that command is correctly defined.
+func (cmd *UserCommand) Validate() error {
+	if cmd.User == "" {
+		return errors.New("user is empty")
+	}
+	return nil
+}
+
+// Validate verifies that command is correctly defined.
+func (cmd *ShellCommand) Validate() error {
+	if len(cmd.Shell) == 0 || cmd.Shell[0] == "" {
+		return errors.New("shell is empty")
+	}
+	return nil
+}
+
+// Validate verifies that command is correctly defined.
+func (cmd *CopyCommand) Validate() error {
+	if len(cmd.Sources) == 0 {
+		return errors.New("no sources passed")
+	}
+	if err := validateNotEmpty(cmd.Sources); err != nil {
+		return err
+	}
+	if cmd.Dest == "" {
+		return errors.New("destination is empty")
+	}
+	if cmd.From != "" {
+		if _, err := types.ParseBuildKey(cmd.From); err != nil {
+			return errors.WithMessagef(err, "invalid source '%s'", cmd.From)
+		}
+	}
+	return nil
+}
+
+// Validate verifies that command is correctly defined.
+func (cmd *TestCommand) Validate() error {
+	return validateCommand(cmd.Command, cmd.Args)
+}
+
+// Validate verifies that command is correctly defined.
+func (cmd *LabelCommand) Validate() error {
+	if cmd.Key == "" || strings.ContainsAny(cmd.Key, "= \t\n") {
+		return errors.Errorf("label key '%s' is invalid", cmd.Key)
+	}
+	return nil
+}
+
+func validateCommand(command string, args []string) error {
+	if len(args) > 0 {
+		if args[0] == "" {
+			return errors.New("executable is empty")
+		}
+		return nil
+	}
+	if command == "" {
+		return errors.New("command is empty")
+	}
+	return nil
+}
+
+func validateNotEmpty(values []string) error {
+	for _, v := range values {
+		if v == "" {
+			return errors.New("empty argument passed")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,254 @@
+package export
+
+import (
+	"archive/tar"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/ridge/must"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	whiteoutPrefix = ".wh."
+	xattrPrefix    = "SCHILY.xattr."
+)
+
+// writeRootFS writes whole root filesystem to tar stream.
+func writeRootFS(w io.Writer, root string) error {
+	a := newArchive(w, root)
+	if err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if path == root {
+			return nil
+		}
+		return a.add(path)
+	}); err != nil {
+		return err
+	}
+	return a.Close()
+}
+
+// writeDiff writes to tar stream entries of root filesystem which are different than the ones in parent,
+// together with whiteouts of entries removed from parent.
+func writeDiff(w io.Writer, parent, root string) error {
+	a := newArchive(w, root)
+
+	// Whiteouts are written first, so they never hide entries added by the layer.
+	if err := filepath.WalkDir(parent, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if path == parent {
+			return nil
+		}
+
+		rel := must.String(filepath.Rel(parent, path))
+		info, err := os.Lstat(filepath.Join(root, rel))
+		switch {
+		case err == nil:
+			if d.IsDir() && !info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		case errors.Is(err, os.ErrNotExist) || errors.Is(err, syscall.ENOTDIR):
+			if err := a.whiteout(rel); err != nil {
+				return err
+			}
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		default:
+			return errors.WithStack(err)
+		}
+	}); err != nil {
+		return err
+	}
+
+	if err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if path == root {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		parentInfo, err := os.Lstat(filepath.Join(parent, must.String(filepath.Rel(root, path))))
+		switch {
+		case err == nil:
+			if !changed(parentInfo, info) {
+				return nil
+			}
+		case errors.Is(err, os.ErrNotExist) || errors.Is(err, syscall.ENOTDIR):
+		default:
+			return errors.WithStack(err)
+		}
+		return a.add(path)
+	}); err != nil {
+		return err
+	}
+	return a.Close()
+}
+
+// changed returns true if entry was modified. Clones of the build share inodes with it, so any modification
+// of the content or metadata is reflected by the change time.
+func changed(parent, child fs.FileInfo) bool {
+	parentStat := parent.Sys().(*syscall.Stat_t)
+	childStat := child.Sys().(*syscall.Stat_t)
+	return parent.Mode() != child.Mode() ||
+		parentStat.Ino != childStat.Ino ||
+		parentStat.Size != childStat.Size ||
+		parentStat.Uid != childStat.Uid ||
+		parentStat.Gid != childStat.Gid ||
+		parentStat.Rdev != childStat.Rdev ||
+		parentStat.Mtim != childStat.Mtim ||
+		parentStat.Ctim != childStat.Ctim
+}
+
+func newArchive(w io.Writer, root string) *archive {
+	return &archive{
+		tw:    tar.NewWriter(w),
+		root:  root,
+		links: map[uint64]string{},
+	}
+}
+
+// archive writes entries of the root filesystem to tar stream.
+type archive struct {
+	tw    *tar.Writer
+	root  string
+	links map[uint64]string
+}
+
+// add writes entry to the archive. Sockets can't be archived, so they are skipped.
+func (a *archive) add(path string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if info.Mode()&fs.ModeSocket != 0 {
+		return nil
+	}
+
+	var link string
+	if info.Mode()&fs.ModeSymlink != 0 {
+		link, err = os.Readlink(path)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	header, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	name := filepath.ToSlash(must.String(filepath.Rel(a.root, path)))
+	if info.IsDir() {
+		name += "/"
+	}
+	header.Name = name
+	// Names of users and groups are resolved using the host, so they are meaningless inside the image.
+	header.Uname = ""
+	header.Gname = ""
+	header.AccessTime = time.Time{}
+	header.ChangeTime = time.Time{}
+
+	if stat := info.Sys().(*syscall.Stat_t); info.Mode().IsRegular() && stat.Nlink > 1 {
+		if target, exists := a.links[stat.Ino]; exists {
+			header.Typeflag = tar.TypeLink
+			header.Linkname = target
+			header.Size = 0
+		} else {
+			a.links[stat.Ino] = name
+		}
+	}
+
+	xattrs, err := readXattrs(path)
+	if err != nil {
+		return err
+	}
+	if len(xattrs) > 0 {
+		header.PAXRecords = map[string]string{}
+		for k, v := range xattrs {
+			header.PAXRecords[xattrPrefix+k] = v
+		}
+		header.Format = tar.FormatPAX
+	}
+
+	if err := a.tw.WriteHeader(header); err != nil {
+		return errors.Wrapf(err, "writing header of '%s' failed", name)
+	}
+	if header.Typeflag != tar.TypeReg || header.Size == 0 {
+		return nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer f.Close()
+
+	_, err = io.Copy(a.tw, f)
+	return errors.Wrapf(err, "writing content of '%s' failed", name)
+}
+
+// whiteout writes entry marking removal of the path.
+func (a *archive) whiteout(path string) error {
+	dir, file := filepath.Split(filepath.ToSlash(path))
+	return errors.WithStack(a.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     dir + whiteoutPrefix + file,
+		Mode:     0o600,
+	}))
+}
+
+// Close finishes the archive.
+func (a *archive) Close() error {
+	return errors.WithStack(a.tw.Close())
+}
+
+func readXattrs(path string) (map[string]string, error) {
+	size, err := unix.Llistxattr(path, nil)
+	if err != nil {
+		if errors.Is(err, unix.ENOTSUP) {
+			return nil, nil
+		}
+		return nil, errors.WithStack(err)
+	}
+	if size == 0 {
+		return nil, nil
+	}
+
+	buf := make([]byte, size)
+	size, err = unix.Llistxattr(path, buf)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	xattrs := map[string]string{}
+	for _, name := range strings.Split(strings.TrimSuffix(string(buf[:size]), "\x00"), "\x00") {
+		valueSize, err := unix.Lgetxattr(path, name, nil)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		value := make([]byte, valueSize)
+		valueSize, err = unix.Lgetxattr(path, name, value)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		xattrs[name] = string(value[:valueSize])
+	}
+	return xattrs, nil
+}
@@ -0,0 +1,78 @@
+package export
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestWriteDiff(t *testing.T) {
+	parent := t.TempDir()
+	root := t.TempDir()
+
+	for _, dir := range []string{"d", "gone/sub"} {
+		if err := os.MkdirAll(filepath.Join(parent, dir), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, file := range []string{"a", "keep", "d/x", "gone/sub/y"} {
+		if err := os.WriteFile(filepath.Join(parent, file), []byte(file), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Clones of the build share inodes with it, hardlinks mimic that.
+	if err := os.Mkdir(filepath.Join(root, "d"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(filepath.Join(parent, "keep"), filepath.Join(root, "keep")); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{"b", "d/z"} {
+		if err := os.WriteFile(filepath.Join(root, file), []byte(file), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	if err := writeDiff(buf, parent, root); err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	tr := tar.NewReader(buf)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, header.Name)
+	}
+
+	isWhiteout := func(name string) bool {
+		return strings.HasPrefix(filepath.Base(name), whiteoutPrefix)
+	}
+	whiteouts := names[:slices.IndexFunc(names, func(name string) bool { return !isWhiteout(name) })]
+	entries := names[len(whiteouts):]
+
+	slices.Sort(whiteouts)
+	if expected := []string{".wh.a", ".wh.gone", "d/.wh.x"}; !slices.Equal(whiteouts, expected) {
+		t.Errorf("unexpected whiteouts: %v, expected: %v", whiteouts, expected)
+	}
+	if slices.ContainsFunc(entries, isWhiteout) {
+		t.Errorf("whiteouts must precede other entries: %v", names)
+	}
+	slices.Sort(entries)
+	if expected := []string{"b", "d/", "d/z"}; !slices.Equal(entries, expected) {
+		t.Errorf("unexpected entries: %v, expected: %v", entries, expected)
+	}
+}
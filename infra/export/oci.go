@@ -0,0 +1,283 @@
+package export
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/pkg/errors"
+	"github.com/ridge/must"
+	"go.uber.org/zap"
+
+	"github.com/outofforest/logger"
+	"github.com/outofforest/osman/config"
+	"github.com/outofforest/osman/infra/types"
+)
+
+const (
+	mediaTypeOCIIndex     = "application/vnd.oci.image.index.v1+json"
+	mediaTypeOCIManifest  = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeOCIConfig    = "application/vnd.oci.image.config.v1+json"
+	mediaTypeOCILayerGzip = "application/vnd.oci.image.layer.v1.tar+gzip"
+
+	ociLayoutVersion = "1.0.0"
+
+	annotationCreated = "org.opencontainers.image.created"
+	annotationTitle   = "org.opencontainers.image.title"
+	annotationRefName = "org.opencontainers.image.ref.name"
+
+	// annotationPrefix prefixes annotations storing osman-specific information about the build.
+	annotationPrefix  = "co.exw.osman."
+	annotationBuildID = annotationPrefix + "build-id"
+	annotationParams  = annotationPrefix + "params"
+	annotationBoots   = annotationPrefix + "boots"
+)
+
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type ociIndex struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType"`
+	Manifests     []ociDescriptor `json:"manifests"`
+}
+
+type ociManifest struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType"`
+	Config        ociDescriptor     `json:"config"`
+	Layers        []ociDescriptor   `json:"layers"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+type ociConfig struct {
+	Created      time.Time        `json:"created"`
+	Architecture string           `json:"architecture"`
+	OS           string           `json:"os"`
+	Config       ociConfigConfig  `json:"config"`
+	RootFS       ociRootFS        `json:"rootfs"`
+	History      []ociHistoryItem `json:"history"`
+}
+
+type ociConfigConfig struct {
+	Labels map[string]string `json:"Labels,omitempty"` //nolint:tagliatelle
+}
+
+type ociRootFS struct {
+	Type    string   `json:"type"`
+	DiffIDs []string `json:"diff_ids"` //nolint:tagliatelle
+}
+
+type ociHistoryItem struct {
+	Created   time.Time `json:"created"`
+	CreatedBy string    `json:"created_by"` //nolint:tagliatelle
+	Comment   string    `json:"comment,omitempty"`
+}
+
+// NewOCIExporter creates new exporter producing OCI image layout.
+func NewOCIExporter() Exporter {
+	return &ociExporter{}
+}
+
+type ociExporter struct{}
+
+// Export writes image to the OCI image layout directory. Each layer of the image is stored as a diff
+// between the build and its parent.
+func (e *ociExporter) Export(ctx context.Context, image Image, output string) error {
+	if output == config.ExportStdout {
+		return errors.New("oci format can't be written to standard output")
+	}
+	blobsDir := filepath.Join(output, "blobs", "sha256")
+	if err := prepareLayout(output, blobsDir); err != nil {
+		return err
+	}
+
+	imageConfig := ociConfig{
+		Created:      image.Build.CreatedAt.UTC(),
+		Architecture: runtime.GOARCH,
+		OS:           "linux",
+		Config: ociConfigConfig{
+			Labels: image.Build.Labels,
+		},
+		RootFS: ociRootFS{
+			Type: "layers",
+		},
+	}
+	layers := make([]ociDescriptor, 0, len(image.Layers))
+	for i, layer := range image.Layers {
+		logger.Get(ctx).Info("Exporting layer", zap.String("buildID", string(layer.Build.BuildID)),
+			zap.String("name", layer.Build.Name))
+
+		desc, diffID, err := writeLayer(blobsDir, func(w io.Writer) error {
+			if i == 0 {
+				return writeRootFS(w, layer.Root)
+			}
+			return writeDiff(w, image.Layers[i-1].Root, layer.Root)
+		})
+		if err != nil {
+			return err
+		}
+		layers = append(layers, desc)
+		imageConfig.RootFS.DiffIDs = append(imageConfig.RootFS.DiffIDs, diffID)
+		imageConfig.History = append(imageConfig.History, ociHistoryItem{
+			Created:   layer.Build.CreatedAt.UTC(),
+			CreatedBy: "osman",
+			Comment:   layer.Build.Name + " " + string(layer.Build.BuildID),
+		})
+	}
+
+	configDesc, err := writeJSONBlob(blobsDir, mediaTypeOCIConfig, imageConfig)
+	if err != nil {
+		return err
+	}
+	manifestDesc, err := writeJSONBlob(blobsDir, mediaTypeOCIManifest, ociManifest{
+		SchemaVersion: 2,
+		MediaType:     mediaTypeOCIManifest,
+		Config:        configDesc,
+		Layers:        layers,
+		Annotations:   annotations(image.Build),
+	})
+	if err != nil {
+		return err
+	}
+	if len(image.Build.Tags) > 0 {
+		manifestDesc.Annotations = map[string]string{
+			annotationRefName: string(image.Build.Tags[0]),
+		}
+	}
+
+	if err := writeJSONFile(filepath.Join(output, "index.json"), ociIndex{
+		SchemaVersion: 2,
+		MediaType:     mediaTypeOCIIndex,
+		Manifests:     []ociDescriptor{manifestDesc},
+	}); err != nil {
+		return err
+	}
+	return writeJSONFile(filepath.Join(output, "oci-layout"), struct {
+		ImageLayoutVersion string `json:"imageLayoutVersion"`
+	}{ImageLayoutVersion: ociLayoutVersion})
+}
+
+// annotations maps information about the build to the annotations of the manifest. Labels may override
+// standard annotations but not the ones specific to osman.
+func annotations(build types.BuildInfo) map[string]string {
+	res := map[string]string{
+		annotationCreated: build.CreatedAt.UTC().Format(time.RFC3339),
+		annotationTitle:   build.Name,
+	}
+	for k, v := range build.Labels {
+		res[k] = v
+	}
+	res[annotationBuildID] = string(build.BuildID)
+	if len(build.Params) > 0 {
+		res[annotationParams] = strings.Join(build.Params, " ")
+	}
+	if len(build.Boots) > 0 {
+		res[annotationBoots] = string(must.Bytes(json.Marshal(build.Boots)))
+	}
+	return res
+}
+
+// prepareLayout creates directories of the layout. Existing output directory must be empty.
+func prepareLayout(output, blobsDir string) error {
+	entries, err := os.ReadDir(output)
+	switch {
+	case err == nil:
+		if len(entries) > 0 {
+			return errors.Errorf("output directory %s is not empty", output)
+		}
+	case errors.Is(err, os.ErrNotExist):
+	default:
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(os.MkdirAll(blobsDir, 0o755))
+}
+
+// writeLayer writes gzip-compressed layer to the blob. Digest of uncompressed content is returned too.
+func writeLayer(blobsDir string, fn func(w io.Writer) error) (ociDescriptor, string, error) {
+	var diffID string
+	desc, err := writeBlob(blobsDir, mediaTypeOCILayerGzip, func(w io.Writer) error {
+		hasher := sha256.New()
+		gz := gzip.NewWriter(w)
+		if err := writeBuffered(io.MultiWriter(gz, hasher), fn); err != nil {
+			return err
+		}
+		if err := gz.Close(); err != nil {
+			return errors.WithStack(err)
+		}
+		diffID = "sha256:" + hex.EncodeToString(hasher.Sum(nil))
+		return nil
+	})
+	if err != nil {
+		return ociDescriptor{}, "", err
+	}
+	return desc, diffID, nil
+}
+
+func writeJSONBlob(blobsDir, mediaType string, v interface{}) (ociDescriptor, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return ociDescriptor{}, errors.WithStack(err)
+	}
+	return writeBlob(blobsDir, mediaType, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return errors.WithStack(err)
+	})
+}
+
+// writeBlob writes content to the temporary file and moves it to the blob named by its digest.
+func writeBlob(blobsDir, mediaType string, fn func(w io.Writer) error) (ociDescriptor, error) {
+	f, err := os.CreateTemp(blobsDir, ".tmp-*")
+	if err != nil {
+		return ociDescriptor{}, errors.WithStack(err)
+	}
+	defer func() {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+	}()
+
+	hasher := sha256.New()
+	if err := writeBuffered(io.MultiWriter(f, hasher), fn); err != nil {
+		return ociDescriptor{}, err
+	}
+	size, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return ociDescriptor{}, errors.WithStack(err)
+	}
+	if err := f.Chmod(0o644); err != nil {
+		return ociDescriptor{}, errors.WithStack(err)
+	}
+	if err := f.Close(); err != nil {
+		return ociDescriptor{}, errors.WithStack(err)
+	}
+
+	digest := hex.EncodeToString(hasher.Sum(nil))
+	if err := os.Rename(f.Name(), filepath.Join(blobsDir, digest)); err != nil {
+		return ociDescriptor{}, errors.WithStack(err)
+	}
+	return ociDescriptor{
+		MediaType: mediaType,
+		Digest:    "sha256:" + digest,
+		Size:      size,
+	}, nil
+}
+
+func writeJSONFile(file string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(os.WriteFile(file, data, 0o644))
+}
@@ -0,0 +1,56 @@
+package export
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+
+	"github.com/outofforest/osman/config"
+)
+
+// NewTarExporter creates new exporter producing tarball of root filesystem.
+func NewTarExporter() Exporter {
+	return &tarExporter{}
+}
+
+type tarExporter struct{}
+
+// Export writes root filesystem of the image to the tarball.
+func (e *tarExporter) Export(ctx context.Context, image Image, output string) error {
+	if len(image.Layers) > 1 {
+		return errors.New("tar format doesn't support layers")
+	}
+
+	return writeOutput(output, func(w io.Writer) error {
+		return writeRootFS(w, image.Root())
+	})
+}
+
+// writeOutput writes content to the output file or to the standard output.
+func writeOutput(output string, fn func(w io.Writer) error) error {
+	if output == config.ExportStdout {
+		return writeBuffered(os.Stdout, fn)
+	}
+
+	f, err := os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer f.Close()
+
+	if err := writeBuffered(f, fn); err != nil {
+		return err
+	}
+	return errors.WithStack(f.Close())
+}
+
+func writeBuffered(w io.Writer, fn func(w io.Writer) error) error {
+	buf := bufio.NewWriter(w)
+	if err := fn(buf); err != nil {
+		return err
+	}
+	return errors.WithStack(buf.Flush())
+}
@@ -0,0 +1,46 @@
+package export
+
+import (
+	"context"
+
+	"github.com/outofforest/ioc/v2"
+	"github.com/outofforest/osman/config"
+	"github.com/outofforest/osman/infra/types"
+)
+
+// Layer is the build mounted for export.
+type Layer struct {
+	// Build is the information about the build.
+	Build types.BuildInfo
+
+	// Root is the directory where root filesystem of the build is mounted.
+	Root string
+}
+
+// Image is the exported image.
+type Image struct {
+	// Build is the information about exported build.
+	Build types.BuildInfo
+
+	// Layers are the exported build and its ancestors, the oldest one first. If layers were not requested,
+	// only the exported build is present.
+	Layers []Layer
+}
+
+// Root returns the directory where root filesystem of exported build is mounted.
+func (i Image) Root() string {
+	return i.Layers[len(i.Layers)-1].Root
+}
+
+// Exporter exports image.
+type Exporter interface {
+	// Export exports image to the output.
+	Export(ctx context.Context, image Image, output string) error
+}
+
+// Resolve resolves concrete exporter based on config.
+func Resolve(c *ioc.Container, config config.Export) Exporter {
+	var exporter Exporter
+	c.ResolveNamed(config.Format, &exporter)
+	return exporter
+}
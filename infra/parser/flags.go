@@ -0,0 +1,101 @@
+package parser
+
+import (
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/outofforest/osman/infra/description"
+)
+
+// parseFlags parses flags in the form of `--name=value` and verifies that only allowed flags are used.
+func parseFlags(flags []string, allowed ...string) (map[string][]string, error) {
+	res := map[string][]string{}
+	for _, f := range flags {
+		if !strings.HasPrefix(f, "--") {
+			return nil, errors.Errorf("invalid flag '%s'", f)
+		}
+		name, value, ok := strings.Cut(f[2:], "=")
+		if !ok || value == "" {
+			return nil, errors.Errorf("value is missing for flag '%s'", f)
+		}
+		if !inSlice(allowed, name) {
+			return nil, errors.Errorf("unknown flag '%s'", name)
+		}
+		res[name] = append(res[name], value)
+	}
+	return res, nil
+}
+
+func inSlice(slice []string, el string) bool {
+	for _, s := range slice {
+		if s == el {
+			return true
+		}
+	}
+	return false
+}
+
+// parseMount parses mount defined as comma-separated list of `key=value` pairs.
+func parseMount(value string) (description.Mount, error) {
+	var mountType, id, target, sharing string
+	var uid, gid *uint32
+	var mode os.FileMode
+	for _, field := range strings.Split(value, ",") {
+		k, v, ok := strings.Cut(field, "=")
+		if !ok || v == "" {
+			return description.Mount{}, errors.Errorf("invalid mount option '%s'", field)
+		}
+		switch k {
+		case "type":
+			mountType = v
+		case "id":
+			id = v
+		case "target", "dst", "destination":
+			target = v
+		case "sharing":
+			sharing = v
+		case "uid", "gid":
+			n, err := strconv.ParseUint(v, 10, 32)
+			if err != nil {
+				return description.Mount{}, errors.Errorf("invalid %s '%s'", k, v)
+			}
+			value := uint32(n)
+			if k == "uid" {
+				uid = &value
+			} else {
+				gid = &value
+			}
+		case "mode":
+			n, err := strconv.ParseUint(v, 8, 32)
+			if err != nil {
+				return description.Mount{}, errors.Errorf("invalid mode '%s'", v)
+			}
+			mode = os.FileMode(n)
+		default:
+			return description.Mount{}, errors.Errorf("unknown mount option '%s'", k)
+		}
+	}
+
+	var mount description.Mount
+	switch description.MountType(mountType) {
+	case description.MountTypeCache:
+		mount = description.CacheMount(id, target, description.Sharing(sharing))
+	case description.MountTypeSecret:
+		mount = description.SecretMount(id, target)
+		mount.Sharing = description.Sharing(sharing)
+	default:
+		return description.Mount{}, errors.Errorf("unsupported mount type '%s'", mountType)
+	}
+
+	mount.UID = uid
+	mount.GID = gid
+	mount.Mode = mode
+
+	if err := mount.Validate(); err != nil {
+		return description.Mount{}, err
+	}
+	return mount, nil
+}
@@ -0,0 +1,143 @@
+package parser
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/outofforest/osman/infra/description"
+)
+
+func TestParseFlags(t *testing.T) {
+	tests := []struct {
+		flags    []string
+		expected map[string][]string
+		valid    bool
+	}{
+		{
+			flags:    nil,
+			expected: map[string][]string{},
+			valid:    true,
+		},
+		{
+			flags:    []string{"--network=none"},
+			expected: map[string][]string{"network": {"none"}},
+			valid:    true,
+		},
+		{
+			flags: []string{"--mount=type=cache,target=/a", "--network=host", "--mount=type=secret,id=b"},
+			expected: map[string][]string{
+				"mount":   {"type=cache,target=/a", "type=secret,id=b"},
+				"network": {"host"},
+			},
+			valid: true,
+		},
+		{flags: []string{"-network=none"}},
+		{flags: []string{"network=none"}},
+		{flags: []string{"--network"}},
+		{flags: []string{"--network="}},
+		{flags: []string{"--unknown=value"}},
+	}
+
+	for _, test := range tests {
+		flags, err := parseFlags(test.flags, "network", "mount")
+		if !test.valid {
+			if err == nil {
+				t.Errorf("%v: error expected", test.flags)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %s", test.flags, err)
+			continue
+		}
+		if !reflect.DeepEqual(flags, test.expected) {
+			t.Errorf("%v: unexpected flags: %v", test.flags, flags)
+		}
+	}
+}
+
+func TestParseMount(t *testing.T) {
+	uid := uint32(1000)
+	gid := uint32(100)
+
+	tests := []struct {
+		value    string
+		expected description.Mount
+		valid    bool
+	}{
+		{
+			value: "type=cache,target=/root/.cache/go-build",
+			expected: description.Mount{
+				Type:    description.MountTypeCache,
+				ID:      "root-.cache-go-build",
+				Target:  "/root/.cache/go-build",
+				Sharing: description.SharingShared,
+			},
+			valid: true,
+		},
+		{
+			value: "type=cache,id=go,dst=/go,sharing=locked",
+			expected: description.Mount{
+				Type:    description.MountTypeCache,
+				ID:      "go",
+				Target:  "/go",
+				Sharing: description.SharingLocked,
+			},
+			valid: true,
+		},
+		{
+			value: "type=secret,id=token",
+			expected: description.Mount{
+				Type:   description.MountTypeSecret,
+				ID:     "token",
+				Target: "/run/secrets/token",
+			},
+			valid: true,
+		},
+		{
+			value: "type=secret,destination=/etc/token,uid=1000,gid=100,mode=440",
+			expected: description.Mount{
+				Type:   description.MountTypeSecret,
+				ID:     "token",
+				Target: "/etc/token",
+				UID:    &uid,
+				GID:    &gid,
+				Mode:   os.FileMode(0o440),
+			},
+			valid: true,
+		},
+		{value: "type=bind,target=/a"},
+		{value: "target=/a"},
+		{value: "type=cache"},
+		{value: "type=cache,target=relative"},
+		{value: "type=cache,target=/a,sharing=private"},
+		{value: "type=cache,target=/a,uid=1000"},
+		{value: "type=cache,id=../x,target=/a"},
+		{value: "type=secret,id=token,sharing=locked"},
+		{value: "type=secret,id=token,uid=-1"},
+		{value: "type=secret,id=token,mode=999"},
+		{value: "type=secret,id=token,mode=10000"},
+		{value: "type=secret,id=../token"},
+		{value: "type=secret,id="},
+		{value: "type=secret,id"},
+		{value: "type=secret,id=token,unknown=1"},
+	}
+
+	for _, test := range tests {
+		mount, err := parseMount(test.value)
+		if !test.valid {
+			if err == nil {
+				t.Errorf("%s: error expected", test.value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.value, err)
+			continue
+		}
+		if !reflect.DeepEqual(mount, test.expected) {
+			t.Errorf("%s: unexpected mount: %s", test.value, mount)
+		}
+	}
+}
@@ -3,7 +3,10 @@ package parser
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 
@@ -34,6 +37,11 @@ func (p *specFileParser) Parse(filePath string) ([]description.Command, error) {
 		return nil, errors.WithStack(err)
 	}
 
+	absPath, err := filepath.Abs(filePath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
 	commands := make([]description.Command, 0, len(parsed.AST.Children))
 	for _, child := range parsed.AST.Children {
 		args := []string{}
@@ -45,15 +53,27 @@ func (p *specFileParser) Parse(filePath string) ([]description.Command, error) {
 		var err error
 		switch strings.ToLower(child.Value) {
 		case "from":
-			cmds, err = p.cmdFrom(args)
+			cmds, err = p.cmdFrom(child.Flags, args)
 		case "params":
 			cmds, err = p.cmdParams(args)
 		case "run":
-			cmds, err = p.cmdRun(args)
+			cmds, err = p.cmdRun(child.Flags, args, child.Attributes["json"])
 		case "include":
 			cmds, err = p.cmdInclude(args)
 		case "boot":
 			cmds, err = p.cmdBoot(args)
+		case "workdir":
+			cmds, err = p.cmdWorkdir(args)
+		case "user":
+			cmds, err = p.cmdUser(args)
+		case "shell":
+			cmds, err = p.cmdShell(args, child.Attributes["json"])
+		case "copy":
+			cmds, err = p.cmdCopy(child.Flags, args)
+		case "label":
+			cmds, err = p.cmdLabel(args)
+		case "test":
+			cmds, err = p.cmdTest(args, child.Attributes["json"])
 		default:
 			return nil, errors.Errorf("unknown command '%s' in line %d", child.Value, child.StartLine)
 		}
@@ -62,25 +82,60 @@ func (p *specFileParser) Parse(filePath string) ([]description.Command, error) {
 			return nil, errors.WithStack(fmt.Errorf("error in line %d of %s command: %w", child.StartLine, child.Value, err))
 		}
 
+		// Commands coming from included files have been already located.
+		for _, cmd := range cmds {
+			description.Locate(cmd, description.Source{File: absPath, Line: child.StartLine})
+		}
 		commands = append(commands, cmds...)
 	}
 	return commands, nil
 }
 
-func (p *specFileParser) cmdFrom(args []string) ([]description.Command, error) {
-	if len(args) != 1 {
-		return nil, errors.Errorf("incorrect number of arguments, expected: 1, got: %d", len(args))
+func (p *specFileParser) cmdFrom(flags, args []string) ([]description.Command, error) {
+	parsedFlags, err := parseFlags(flags, "sha256")
+	if err != nil {
+		return nil, err
+	}
+
+	if len(args) != 1 && len(args) != 3 {
+		return nil, errors.Errorf("incorrect number of arguments, expected: 1 or 3, got: %d", len(args))
 	}
 	if args[0] == "" {
 		return nil, errors.New("first argument is empty")
 	}
 
+	var stage string
+	if len(args) == 3 {
+		if !strings.EqualFold(args[1], "as") {
+			return nil, errors.Errorf("expected AS, got: %s", args[1])
+		}
+		if !types.IsNameValid(args[2]) {
+			return nil, errors.Errorf("stage name '%s' is invalid", args[2])
+		}
+		stage = args[2]
+	}
+
+	var options []description.FromOption
+	if sha256 := parsedFlags["sha256"]; len(sha256) > 0 {
+		if len(sha256) > 1 {
+			return nil, errors.New("--sha256 flag might be specified once")
+		}
+		options = append(options, description.WithDigest("sha256:"+strings.ToLower(sha256[0])))
+	}
+
+	// Base image might be referenced using scheme selecting the initializer, e.g. docker://fedora:40.
+	if types.IsBaseReference(args[0]) {
+		return []description.Command{description.FromBaseAs(args[0], stage, options...)}, nil
+	}
+	if len(options) > 0 {
+		return nil, errors.New("--sha256 flag is supported only by base images referenced using scheme")
+	}
+
 	buildKey, err := types.ParseBuildKey(args[0])
 	if err != nil {
 		return nil, err
 	}
-
-	return []description.Command{description.From(buildKey)}, nil
+	return []description.Command{description.FromAs(buildKey, stage)}, nil
 }
 
 func (p *specFileParser) cmdParams(args []string) ([]description.Command, error) {
@@ -90,14 +145,104 @@ func (p *specFileParser) cmdParams(args []string) ([]description.Command, error)
 	return []description.Command{description.Params(args...)}, nil
 }
 
-func (p *specFileParser) cmdRun(args []string) ([]description.Command, error) {
+func (p *specFileParser) cmdRun(flags, args []string, json bool) ([]description.Command, error) {
+	parsedFlags, err := parseFlags(flags, "network", "mount", "timeout", "cpus", "memory", "pids")
+	if err != nil {
+		return nil, err
+	}
+
+	var options []description.RunOption
+	for _, v := range parsedFlags["network"] {
+		network := description.Network(v)
+		if network == description.NetworkDefault || !network.IsValid() {
+			return nil, errors.Errorf("invalid network '%s'", v)
+		}
+		options = append(options, description.WithNetwork(network))
+	}
+	for _, v := range parsedFlags["mount"] {
+		mount, err := parseMount(v)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, description.WithMount(mount))
+	}
+	for _, v := range parsedFlags["timeout"] {
+		timeout, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid timeout '%s'", v)
+		}
+		if timeout <= 0 {
+			return nil, errors.Errorf("timeout '%s' must be positive", v)
+		}
+		options = append(options, description.WithTimeout(timeout))
+	}
+	for _, v := range parsedFlags["cpus"] {
+		cpus, err := strconv.ParseFloat(v, 64)
+		if err != nil || cpus <= 0 {
+			return nil, errors.Errorf("invalid number of cpus '%s'", v)
+		}
+		options = append(options, description.WithLimits(types.Limits{CPUs: cpus}))
+	}
+	for _, v := range parsedFlags["memory"] {
+		memory, err := types.ParseMemory(v)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, description.WithLimits(types.Limits{Memory: memory}))
+	}
+	for _, v := range parsedFlags["pids"] {
+		pids, err := strconv.ParseUint(v, 10, 64)
+		if err != nil || pids == 0 {
+			return nil, errors.Errorf("invalid number of pids '%s'", v)
+		}
+		options = append(options, description.WithLimits(types.Limits{Pids: pids}))
+	}
+
+	if json {
+		if len(args) == 0 {
+			return nil, errors.New("no arguments passed")
+		}
+		if args[0] == "" {
+			return nil, errors.New("first argument is empty")
+		}
+		return []description.Command{description.RunExec(args, options...)}, nil
+	}
+
 	if len(args) != 1 {
 		return nil, errors.Errorf("incorrect number of arguments, expected: 1, got: %d", len(args))
 	}
 	if args[0] == "" {
 		return nil, errors.New("first argument is empty")
 	}
-	return []description.Command{description.Run(args[0])}, nil
+	return []description.Command{description.Run(args[0], options...)}, nil
+}
+
+func (p *specFileParser) cmdCopy(flags, args []string) ([]description.Command, error) {
+	parsedFlags, err := parseFlags(flags, "from")
+	if err != nil {
+		return nil, err
+	}
+
+	var options []description.CopyOption
+	if from := parsedFlags["from"]; len(from) > 0 {
+		if len(from) > 1 {
+			return nil, errors.New("--from flag might be specified once")
+		}
+		if from[0] == "" {
+			return nil, errors.New("--from flag is empty")
+		}
+		options = append(options, description.CopyFrom(from[0]))
+	}
+
+	if len(args) < 2 {
+		return nil, errors.Errorf("incorrect number of arguments, expected at least 2, got: %d", len(args))
+	}
+	for _, arg := range args {
+		if arg == "" {
+			return nil, errors.New("empty argument passed")
+		}
+	}
+	return []description.Command{description.Copy(args[:len(args)-1], args[len(args)-1], options...)}, nil
 }
 
 func (p *specFileParser) cmdInclude(args []string) ([]description.Command, error) {
@@ -142,3 +287,67 @@ func (p *specFileParser) cmdBoot(args []string) ([]description.Command, error) {
 	}
 	return []description.Command{description.Boot(args[0], params)}, nil
 }
+
+func (p *specFileParser) cmdWorkdir(args []string) ([]description.Command, error) {
+	if len(args) != 1 {
+		return nil, errors.Errorf("incorrect number of arguments, expected: 1, got: %d", len(args))
+	}
+	if args[0] == "" {
+		return nil, errors.New("first argument is empty")
+	}
+	return []description.Command{description.Workdir(args[0])}, nil
+}
+
+func (p *specFileParser) cmdUser(args []string) ([]description.Command, error) {
+	if len(args) != 1 {
+		return nil, errors.Errorf("incorrect number of arguments, expected: 1, got: %d", len(args))
+	}
+	if args[0] == "" {
+		return nil, errors.New("first argument is empty")
+	}
+	return []description.Command{description.User(args[0])}, nil
+}
+
+func (p *specFileParser) cmdLabel(args []string) ([]description.Command, error) {
+	if len(args) == 0 {
+		return nil, errors.New("no arguments passed")
+	}
+	cmds := make([]description.Command, 0, len(args))
+	for _, arg := range args {
+		key, value, err := types.ParseLabel(arg, true)
+		if err != nil {
+			return nil, err
+		}
+		cmds = append(cmds, description.Label(key, value))
+	}
+	return cmds, nil
+}
+
+func (p *specFileParser) cmdTest(args []string, json bool) ([]description.Command, error) {
+	if len(args) == 0 {
+		return nil, errors.New("no arguments passed")
+	}
+	if args[0] == "" {
+		return nil, errors.New("first argument is empty")
+	}
+	if json {
+		return []description.Command{description.TestExec(args)}, nil
+	}
+	if len(args) != 1 {
+		return nil, errors.Errorf("incorrect number of arguments, expected: 1, got: %d", len(args))
+	}
+	return []description.Command{description.Test(args[0])}, nil
+}
+
+func (p *specFileParser) cmdShell(args []string, json bool) ([]description.Command, error) {
+	if !json {
+		return nil, errors.New("shell must be specified in JSON form")
+	}
+	if len(args) == 0 {
+		return nil, errors.New("no arguments passed")
+	}
+	if args[0] == "" {
+		return nil, errors.New("first argument is empty")
+	}
+	return []description.Command{description.Shell(args...)}, nil
+}
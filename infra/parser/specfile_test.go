@@ -0,0 +1,94 @@
+package parser
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/outofforest/osman/infra/description"
+)
+
+func parseSpec(t *testing.T, content string) ([]description.Command, error) {
+	t.Helper()
+
+	specFile := filepath.Join(t.TempDir(), "test.spec")
+	if err := os.WriteFile(specFile, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return NewSpecFileParser().Parse(specFile)
+}
+
+func TestLabel(t *testing.T) {
+	tests := []struct {
+		line   string
+		labels [][2]string
+	}{
+		{
+			line:   `LABEL team=platform`,
+			labels: [][2]string{{"team", "platform"}},
+		},
+		{
+			line:   `LABEL team=platform tier=1`,
+			labels: [][2]string{{"team", "platform"}, {"tier", "1"}},
+		},
+		{
+			line:   `LABEL description="value with spaces" tier=1`,
+			labels: [][2]string{{"description", "value with spaces"}, {"tier", "1"}},
+		},
+		{
+			line:   `LABEL description='single "quoted"' "quoted"=x`,
+			labels: [][2]string{{"description", `single "quoted"`}, {"quoted", "x"}},
+		},
+		{
+			line:   `LABEL description=escaped\ space quote="a \"b\""`,
+			labels: [][2]string{{"description", "escaped space"}, {"quote", `a "b"`}},
+		},
+		{
+			line:   `LABEL empty=`,
+			labels: [][2]string{{"empty", ""}},
+		},
+		{
+			line:   `LABEL ["description=value with spaces", "tier=1"]`,
+			labels: [][2]string{{"description", "value with spaces"}, {"tier", "1"}},
+		},
+	}
+
+	for _, test := range tests {
+		commands, err := parseSpec(t, "FROM fedora\n"+test.line+"\n")
+		if err != nil {
+			t.Errorf("%s: %s", test.line, err)
+			continue
+		}
+		commands = commands[1:]
+		if len(commands) != len(test.labels) {
+			t.Errorf("%s: unexpected commands: %v", test.line, commands)
+			continue
+		}
+		for i, label := range test.labels {
+			cmd, ok := commands[i].(*description.LabelCommand)
+			if !ok {
+				t.Errorf("%s: unexpected command: %v", test.line, commands[i])
+				continue
+			}
+			if cmd.Key != label[0] || cmd.Value != label[1] {
+				t.Errorf("%s: unexpected label: %q=%q", test.line, cmd.Key, cmd.Value)
+			}
+		}
+	}
+}
+
+func TestLabelInvalid(t *testing.T) {
+	for _, line := range []string{
+		`LABEL`,
+		`LABEL team`,
+		`LABEL =platform`,
+		`LABEL "key with spaces"=platform`,
+		`LABEL description="unterminated`,
+		`LABEL description='unterminated`,
+		`LABEL ["team=platform", 1]`,
+	} {
+		if _, err := parseSpec(t, "FROM fedora\n"+line+"\n"); err == nil {
+			t.Errorf("%s: error expected", line)
+		}
+	}
+}
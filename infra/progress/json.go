@@ -0,0 +1,27 @@
+package progress
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+// NewJSONReporter returns reporter printing events to stdout as newline-delimited JSON.
+func NewJSONReporter() Reporter {
+	return &jsonReporter{
+		encoder: json.NewEncoder(os.Stdout),
+	}
+}
+
+type jsonReporter struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+}
+
+// Report prints event as JSON object.
+func (r *jsonReporter) Report(event Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_ = r.encoder.Encode(event)
+}
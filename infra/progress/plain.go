@@ -0,0 +1,27 @@
+package progress
+
+import (
+	"os"
+	"sync"
+)
+
+// NewPlainReporter returns reporter printing logs of commands to stderr.
+func NewPlainReporter() Reporter {
+	return &plainReporter{}
+}
+
+type plainReporter struct {
+	mu sync.Mutex
+}
+
+// Report prints log lines to stderr.
+func (r *plainReporter) Report(event Event) {
+	if event.Type != EventLog {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, _ = os.Stderr.WriteString(event.Content + "\n")
+}
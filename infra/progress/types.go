@@ -0,0 +1,98 @@
+package progress
+
+import (
+	"time"
+
+	"github.com/outofforest/ioc/v2"
+	"github.com/outofforest/osman/config"
+	"github.com/outofforest/osman/infra/types"
+)
+
+// EventType is the type of build event.
+type EventType string
+
+const (
+	// EventBuildStart is reported when build of an image starts.
+	// Fields set: Time, BuildID, BuildKeys.
+	EventBuildStart EventType = "buildStart"
+
+	// EventCacheHit is reported when dependency is satisfied by an existing build.
+	// Fields set: Time, BuildID, BuildKeys.
+	EventCacheHit EventType = "cacheHit"
+
+	// EventStepStart is reported when build step starts.
+	// Fields set: Time, BuildID, Step, Source, Command.
+	EventStepStart EventType = "stepStart"
+
+	// EventLog is reported for each line printed by the command.
+	// Fields set: Time, BuildID, Step, Content.
+	EventLog EventType = "log"
+
+	// EventStepEnd is reported when build step finishes.
+	// Fields set: Time, BuildID, Step, Duration, Error (if step failed).
+	EventStepEnd EventType = "stepEnd"
+
+	// EventSnapshot is reported when snapshot of the build is taken.
+	// Fields set: Time, BuildID.
+	EventSnapshot EventType = "snapshot"
+
+	// EventTag is reported when build is tagged.
+	// Fields set: Time, BuildID, BuildKeys.
+	EventTag EventType = "tag"
+
+	// EventBuildEnd is reported when build finishes.
+	// Fields set: Time, BuildID, Duration, Info (if build succeeded), Error (if build failed).
+	EventBuildEnd EventType = "buildEnd"
+)
+
+// Event is the build event.
+//
+// In JSON mode each event is printed as a single line containing JSON object. Fields which are not relevant for
+// the event type are omitted. Duration is expressed in nanoseconds.
+type Event struct {
+	// Type is the type of the event.
+	Type EventType
+
+	// Time is the time when event occurred.
+	Time time.Time
+
+	// BuildID is the ID of the build event is related to.
+	BuildID types.BuildID `json:",omitempty"`
+
+	// BuildKeys are the name and tags of the build.
+	BuildKeys []types.BuildKey `json:",omitempty"`
+
+	// Step is the index of the build step, starting from 1.
+	Step int `json:",omitempty"`
+
+	// Source is the location of the command in the spec file.
+	Source string `json:",omitempty"`
+
+	// Command is the command executed in the step.
+	Command string `json:",omitempty"`
+
+	// Content is the line printed by the command.
+	Content string `json:",omitempty"`
+
+	// Duration is the duration of the step or build.
+	Duration time.Duration `json:",omitempty"`
+
+	// Error is the error returned by the step or build.
+	Error string `json:",omitempty"`
+
+	// Info is the information about finished build.
+	Info *types.BuildInfo `json:",omitempty"`
+}
+
+// Reporter reports build events.
+type Reporter interface {
+	// Report reports build event.
+	Report(event Event)
+}
+
+// Resolve resolves concrete reporter based on config.
+func Resolve(c *ioc.Container, config config.Build) Reporter {
+	var reporter Reporter
+	c.ResolveNamed(config.Progress, &reporter)
+	return reporter
+}
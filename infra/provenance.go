@@ -0,0 +1,148 @@
+package infra
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/outofforest/osman/infra/description"
+	"github.com/outofforest/osman/infra/types"
+)
+
+// newProvenance returns provenance of the image built from spec file and parent build.
+func newProvenance(
+	specFile string,
+	includes []string,
+	from types.BuildKey,
+	fromBuildID types.BuildID,
+	fromStage bool,
+) (*types.Provenance, error) {
+	provenance := &types.Provenance{
+		SpecFile:       specFile,
+		Includes:       includes,
+		From:           from,
+		FromBuildID:    fromBuildID,
+		FromStage:      fromStage,
+		BuilderVersion: builderVersion(),
+	}
+	if specFile != "" {
+		digest, err := specDigest(specFile, includes)
+		if err != nil {
+			return nil, err
+		}
+		provenance.SpecDigest = digest
+	}
+
+	host, err := os.Hostname()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	provenance.Host = host
+
+	// Builds are executed by root, so the user who invoked sudo is more interesting.
+	if sudoUser := os.Getenv("SUDO_USER"); sudoUser != "" {
+		provenance.User = sudoUser
+	} else if u, err := user.Current(); err == nil {
+		provenance.User = u.Username
+	}
+	return provenance, nil
+}
+
+// specDigest returns digest of the content of the spec file and files included by it.
+func specDigest(specFile string, includes []string) (string, error) {
+	hasher := sha256.New()
+	for _, file := range append([]string{specFile}, includes...) {
+		if err := hashFile(hasher, file); err != nil {
+			return "", err
+		}
+	}
+	return "sha256:" + hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+func hashFile(hasher hash.Hash, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer f.Close()
+
+	_, err = io.Copy(hasher, f)
+	return errors.WithStack(err)
+}
+
+// specIncludes returns paths of the files included by the spec file, taken from the commands defined there.
+func specIncludes(specFile string, commands []description.Command) []string {
+	if specFile == "" {
+		return nil
+	}
+
+	var includes []string
+	seen := map[string]bool{specFile: true}
+	for _, cmd := range commands {
+		file := cmd.Source().File
+		if file == "" || seen[file] {
+			continue
+		}
+		seen[file] = true
+		includes = append(includes, file)
+	}
+	return includes
+}
+
+// specCommands returns all the commands image is defined by, with FROM resolved to the build key of the parent.
+func specCommands(from types.BuildKey, commands []description.Command) []types.SpecCommand {
+	res := make([]types.SpecCommand, 0, len(commands))
+	for i, cmd := range commands {
+		command := fmt.Sprint(cmd)
+		if fromCmd, ok := cmd.(*description.FromCommand); ok && i == 0 {
+			command = fmt.Sprint(description.FromAs(from, fromCmd.Stage))
+		}
+		res = append(res, types.SpecCommand{
+			Source:  cmd.Source().String(),
+			Command: command,
+		})
+	}
+	return res
+}
+
+// specPath returns absolute path of the spec file commands were parsed from.
+// Parser may add extension to the path, so it is taken from the commands defined directly in the file.
+func specPath(specFile string, commands []description.Command) string {
+	absPath, err := filepath.Abs(specFile)
+	if err != nil {
+		return ""
+	}
+	for _, cmd := range commands {
+		file := cmd.Source().File
+		if file == absPath || strings.TrimSuffix(file, filepath.Ext(file)) == absPath {
+			return file
+		}
+	}
+	return ""
+}
+
+// builderVersion returns version of the running osman binary.
+func builderVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+
+	version := info.Main.Version
+	for _, s := range info.Settings {
+		if s.Key == "vcs.revision" {
+			version += " (" + s.Value + ")"
+			break
+		}
+	}
+	return version
+}
@@ -0,0 +1,29 @@
+package infra
+
+import (
+	"testing"
+
+	"github.com/outofforest/osman/infra/description"
+	"github.com/outofforest/osman/infra/types"
+)
+
+func TestSpecCommands(t *testing.T) {
+	from := description.FromBase("docker://fedora:40")
+	description.Locate(from, description.Source{File: "/spec/app.spec", Line: 1})
+	run := description.Run("make")
+	description.Locate(run, description.Source{File: "/spec/included.spec", Line: 3})
+
+	commands := specCommands(types.NewBuildKey("fedora", "40"), []description.Command{from, run})
+	expected := []types.SpecCommand{
+		{Source: "/spec/app.spec:1", Command: "FROM fedora@40"},
+		{Source: "/spec/included.spec:3", Command: "RUN make"},
+	}
+	if len(commands) != len(expected) {
+		t.Fatalf("unexpected commands: %v", commands)
+	}
+	for i, cmd := range expected {
+		if commands[i] != cmd {
+			t.Errorf("unexpected command %d: %+v", i, commands[i])
+		}
+	}
+}
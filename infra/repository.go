@@ -1,25 +1,41 @@
 package infra
 
 import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+
 	"github.com/outofforest/osman/infra/description"
 	"github.com/outofforest/osman/infra/types"
 )
 
+// specFileExtension is the extension of spec files found in spec search path.
+const specFileExtension = ".spec"
+
 // NewRepository creates new image repository.
 func NewRepository() *Repository {
 	return &Repository{
-		images: map[types.BuildKey]*description.Descriptor{},
+		images:    map[types.BuildKey]*description.Descriptor{},
+		specFiles: map[types.BuildKey]string{},
 	}
 }
 
 // Repository is an image repository.
 type Repository struct {
-	images map[types.BuildKey]*description.Descriptor
+	images    map[types.BuildKey]*description.Descriptor
+	specFiles map[types.BuildKey]string
+	loaded    bool
 }
 
-// Store stores image descriptor in repository.
+// Store stores image descriptor in repository. Image without tags is stored with the default one.
 func (r *Repository) Store(img *description.Descriptor) {
-	for _, tag := range img.Tags() {
+	tags := img.Tags()
+	if len(tags) == 0 {
+		tags = types.Tags{description.DefaultTag}
+	}
+	for _, tag := range tags {
 		r.images[types.NewBuildKey(img.Name(), tag)] = img
 	}
 }
@@ -28,3 +44,51 @@ func (r *Repository) Store(img *description.Descriptor) {
 func (r *Repository) Retrieve(buildKey types.BuildKey) *description.Descriptor {
 	return r.images[buildKey]
 }
+
+// SpecFile returns path to the spec file of the image found in spec search path.
+func (r *Repository) SpecFile(buildKey types.BuildKey) string {
+	return r.specFiles[buildKey]
+}
+
+// LoadSpecPath scans directories for spec files and registers them in the repository. File <name>.spec defines
+// image name@latest, file <name>@<tag>.spec defines image name@tag. If image is defined in many directories,
+// the first one wins. Directories are scanned only once.
+func (r *Repository) LoadSpecPath(dirs []string) error {
+	if r.loaded {
+		return nil
+	}
+	r.loaded = true
+
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return errors.WithStack(err)
+		}
+		for _, e := range entries {
+			if e.IsDir() || !strings.HasSuffix(e.Name(), specFileExtension) {
+				continue
+			}
+			buildKey, err := types.ParseBuildKey(strings.TrimSuffix(e.Name(), specFileExtension))
+			if err != nil || buildKey.Name == "" {
+				// Files not following naming convention are not images.
+				continue
+			}
+			if buildKey.Tag == "" {
+				buildKey.Tag = description.DefaultTag
+			}
+			if _, exists := r.specFiles[buildKey]; exists {
+				continue
+			}
+
+			path, err := filepath.Abs(filepath.Join(dir, e.Name()))
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			r.specFiles[buildKey] = path
+		}
+	}
+	return nil
+}
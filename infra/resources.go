@@ -0,0 +1,112 @@
+package infra
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/pkg/errors"
+
+	"github.com/outofforest/isolator/wire"
+	"github.com/outofforest/osman/infra/cgroup"
+	"github.com/outofforest/osman/infra/description"
+	"github.com/outofforest/osman/infra/runner"
+	"github.com/outofforest/osman/infra/types"
+)
+
+// newBuildGroup creates cgroup for the build if any limit is set. Resource usage is reported only for such builds.
+func newBuildGroup(buildID types.BuildID, limits types.Limits, commands []description.Command) (*cgroup.Group, error) {
+	limited := !limits.IsZero()
+	for _, cmd := range commands {
+		if runCmd, ok := cmd.(*description.RunCommand); ok && !runCmd.Limits.IsZero() {
+			limited = true
+			break
+		}
+	}
+	if !limited {
+		return nil, nil
+	}
+
+	group, err := cgroup.New(buildID)
+	if err != nil {
+		return nil, errors.WithMessage(err, "resource limits can't be applied")
+	}
+	return group, nil
+}
+
+// attach starts the executor and moves it to the cgroup, so all the commands executed later belong to it.
+func (b *imageBuild) attach(ctx context.Context) error {
+	if b.group == nil {
+		return nil
+	}
+
+	select {
+	case <-ctx.Done():
+		return errors.WithStack(ctx.Err())
+	case b.outgoing <- runner.Ping{}:
+	}
+
+	var pidNamespace string
+	for content := range b.incoming {
+		switch m := content.(type) {
+		case runner.Started:
+			pidNamespace = m.PIDNamespace
+		case wire.Result:
+			if m.Error != "" {
+				return errors.Errorf("starting executor failed: %s", m.Error)
+			}
+			pid, err := findExecutor(pidNamespace)
+			if err != nil {
+				return err
+			}
+			return b.group.Add(pid)
+		default:
+			return errors.New("unexpected message received")
+		}
+	}
+	return errors.WithStack(ctx.Err())
+}
+
+// usage stores resource usage of the build in the manifest.
+func (b *imageBuild) usage() error {
+	if b.group == nil {
+		return nil
+	}
+
+	usage, err := b.group.Usage()
+	if err != nil {
+		return err
+	}
+	b.manifest.Usage = usage
+	return nil
+}
+
+// findExecutor finds PID of the isolator executor running in the PID namespace.
+// Executor is started by isolator as a child of this process and it is the init process of its namespace.
+func findExecutor(pidNamespace string) (int, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	ppid := strconv.Itoa(os.Getpid())
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+
+		status, err := os.ReadFile(filepath.Join("/proc", e.Name(), "status"))
+		if err != nil || !bytes.Contains(status, []byte("\nPPid:\t"+ppid+"\n")) {
+			continue
+		}
+		ns, err := os.Readlink(filepath.Join("/proc", e.Name(), "ns", "pid"))
+		if err != nil || ns != pidNamespace {
+			continue
+		}
+		return pid, nil
+	}
+	return 0, errors.New("executor process not found")
+}
@@ -0,0 +1,62 @@
+package infra
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// maxSymlinks is the maximum number of symlinks followed while resolving path, as in linux kernel.
+const maxSymlinks = 40
+
+// ResolveInRoot resolves path inside the filesystem mounted at root as if root was the root directory.
+// Symlinks are followed, but absolute ones and ".." never escape the root.
+func ResolveInRoot(root, path string) (string, error) {
+	var resolved string
+	remaining := path
+	links := 0
+	for remaining != "" {
+		var part string
+		part, remaining, _ = strings.Cut(strings.TrimLeft(remaining, "/"), "/")
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			resolved = filepath.Dir(resolved)
+			if resolved == "." || resolved == "/" {
+				resolved = ""
+			}
+			continue
+		}
+
+		next := resolved + "/" + part
+		info, err := os.Lstat(filepath.Join(root, next))
+		if err != nil {
+			if os.IsNotExist(err) {
+				resolved = next
+				continue
+			}
+			return "", errors.WithStack(err)
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+
+		links++
+		if links > maxSymlinks {
+			return "", errors.Errorf("too many levels of symbolic links while resolving %s", path)
+		}
+		target, err := os.Readlink(filepath.Join(root, next))
+		if err != nil {
+			return "", errors.WithStack(err)
+		}
+		if filepath.IsAbs(target) {
+			resolved = ""
+		}
+		remaining = target + "/" + remaining
+	}
+	return filepath.Join(root, resolved), nil
+}
@@ -0,0 +1,65 @@
+package infra
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveInRoot(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{"etc", "boot", "usr/lib"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for link, target := range map[string]string{
+		"boot/vmlinuz":     "/boot/vmlinuz-6.x",
+		"boot/initramfs":   "initramfs-6.x.img",
+		"etc/passwd":       "../../../../etc/passwd-image",
+		"lib":              "usr/lib",
+		"etc/escape":       "/..",
+		"etc/absolute-dir": "/usr/lib/",
+		"loop1":            "loop2",
+		"loop2":            "/loop1",
+	} {
+		if err := os.Symlink(target, filepath.Join(root, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		path     string
+		expected string
+		valid    bool
+	}{
+		{path: "/etc/hostname", expected: "/etc/hostname", valid: true},
+		{path: "etc/hostname", expected: "/etc/hostname", valid: true},
+		{path: "/boot/vmlinuz", expected: "/boot/vmlinuz-6.x", valid: true},
+		{path: "/boot/initramfs", expected: "/boot/initramfs-6.x.img", valid: true},
+		{path: "/etc/passwd", expected: "/etc/passwd-image", valid: true},
+		{path: "/lib/modules", expected: "/usr/lib/modules", valid: true},
+		{path: "/../../etc/hostname", expected: "/etc/hostname", valid: true},
+		{path: "/etc/escape/etc", expected: "/etc", valid: true},
+		{path: "/etc/absolute-dir/x", expected: "/usr/lib/x", valid: true},
+		{path: "/./usr//lib/../lib", expected: "/usr/lib", valid: true},
+		{path: "/loop1"},
+	}
+
+	for _, test := range tests {
+		resolved, err := ResolveInRoot(root, test.path)
+		if !test.valid {
+			if err == nil {
+				t.Errorf("%s: error expected, resolved: %s", test.path, resolved)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.path, err)
+			continue
+		}
+		if expected := filepath.Join(root, test.expected); resolved != expected {
+			t.Errorf("%s: expected: %s, got: %s", test.path, expected, resolved)
+		}
+	}
+}
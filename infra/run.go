@@ -0,0 +1,106 @@
+package infra
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/pkg/errors"
+
+	"github.com/outofforest/isolator"
+	"github.com/outofforest/isolator/wire"
+	"github.com/outofforest/osman/config"
+	"github.com/outofforest/osman/infra/runner"
+)
+
+// Run runs command inside the build stored in path, streams its output and returns its exit code.
+func Run(ctx context.Context, path string, run config.Run) (retExitCode int, retErr error) {
+	mounts := make([]wire.Mount, 0, len(run.Mounts))
+	var created []string
+	for _, m := range run.Mounts {
+		created = append(created, missingPaths(path, m.Target)...)
+		mounts = append(mounts, wire.Mount{
+			Host:      m.Source,
+			Namespace: m.Target,
+			Writable:  m.Writable,
+		})
+	}
+	defer func() {
+		// Mountpoints are removed if they didn't exist before. Paths not being empty are left untouched.
+		sort.Slice(created, func(i, j int) bool {
+			return len(created[i]) > len(created[j])
+		})
+		for _, p := range created {
+			_ = os.Remove(p)
+		}
+	}()
+
+	exitCode := -1
+	err := isolator.Run(ctx, isolator.Config{
+		Dir: path,
+		Types: []interface{}{
+			wire.Result{},
+			runner.Output{},
+			runner.Exited{},
+		},
+		Executor: wire.Config{
+			ConfigureSystem: true,
+			UseHostNetwork:  true,
+			Mounts:          mounts,
+		},
+	}, func(ctx context.Context, incoming <-chan interface{}, outgoing chan<- interface{}) error {
+		select {
+		case <-ctx.Done():
+			return errors.WithStack(ctx.Err())
+		case outgoing <- runner.Execute{
+			Args:           run.Command,
+			WorkDir:        run.WorkDir,
+			IsolateNetwork: run.Network == config.NetworkNone,
+			Stream:         true,
+		}:
+		}
+
+		for content := range incoming {
+			switch m := content.(type) {
+			case runner.Output:
+				out := os.Stdout
+				if m.Stderr {
+					out = os.Stderr
+				}
+				if _, err := out.Write(m.Content); err != nil {
+					return errors.WithStack(err)
+				}
+			case runner.Exited:
+				exitCode = m.Code
+			case wire.Result:
+				if m.Error != "" {
+					return errors.Errorf("command failed: %s", m.Error)
+				}
+				return nil
+			default:
+				return errors.New("unexpected message received")
+			}
+		}
+		return errors.WithStack(ctx.Err())
+	})
+	if err != nil {
+		return 0, err
+	}
+	if exitCode < 0 {
+		return 0, errors.New("exit code of the command was not received")
+	}
+	return exitCode, nil
+}
+
+// missingPaths returns the components of target path which don't exist inside the build, the deepest first.
+func missingPaths(path, target string) []string {
+	var res []string
+	for p := filepath.Join(path, target); p != path && p != filepath.Dir(p); p = filepath.Dir(p) {
+		if _, err := os.Lstat(p); err == nil {
+			break
+		}
+		res = append(res, p)
+	}
+	return res
+}
@@ -0,0 +1,245 @@
+package runner
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+
+	"github.com/outofforest/isolator/wire"
+)
+
+// CopyHandler handles Copy command inside isolator.
+func CopyHandler(ctx context.Context, content interface{}, encode wire.EncoderFunc) error {
+	m, ok := content.(Copy)
+	if !ok {
+		return errors.Errorf("unexpected type %T", content)
+	}
+
+	var sources []string
+	for _, pattern := range m.Sources {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if len(matches) == 0 {
+			return errors.Errorf("no files match '%s'", strings.TrimPrefix(pattern, m.Root))
+		}
+		sources = append(sources, matches...)
+	}
+
+	destDir := strings.HasSuffix(m.Dest, "/") || len(sources) > 1
+	if info, err := os.Stat(m.Dest); err == nil && info.IsDir() {
+		destDir = true
+	}
+	if len(sources) > 1 && !strings.HasSuffix(m.Dest, "/") {
+		return errors.Errorf("destination '%s' must end with / when copying multiple files", m.Dest)
+	}
+
+	c := copier{preserveOwnership: m.PreserveOwnership}
+	for _, src := range sources {
+		info, err := os.Lstat(src)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		switch {
+		case info.IsDir():
+			// Like in Dockerfile, content of the directory is copied, not the directory itself.
+			err = c.copyTree(src, m.Dest)
+		case destDir:
+			err = c.copyEntry(src, filepath.Join(m.Dest, filepath.Base(src)), info)
+		default:
+			err = c.copyEntry(src, m.Dest, info)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type copier struct {
+	preserveOwnership bool
+}
+
+func (c copier) copyTree(src, dst string) error {
+	if err := os.MkdirAll(dst, 0o755); err != nil {
+		return errors.WithStack(err)
+	}
+
+	// Modification times of directories are set at the end because they are changed by creating their content.
+	var dirs [][2]string
+	err := filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		// Attributes of the destination directory itself are not changed.
+		if path == src {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		target := filepath.Join(dst, rel)
+		if info.IsDir() {
+			dirs = append(dirs, [2]string{path, target})
+		}
+		return c.copyEntry(path, target, info)
+	})
+	if err != nil {
+		return err
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		info, err := os.Lstat(dirs[i][0])
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if err := setTimes(dirs[i][1], info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c copier) copyEntry(src, dst string, info fs.FileInfo) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return errors.WithStack(err)
+	}
+
+	// Existing entry is replaced unless both are directories, then their content is merged.
+	if existing, err := os.Lstat(dst); err == nil && !(existing.IsDir() && info.IsDir()) {
+		if err := os.RemoveAll(dst); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return errors.Errorf("unsupported file info of '%s'", src)
+	}
+
+	switch info.Mode().Type() {
+	case 0:
+		if err := copyFile(src, dst); err != nil {
+			return err
+		}
+	case fs.ModeDir:
+		if err := os.Mkdir(dst, 0o700); err != nil && !os.IsExist(err) {
+			return errors.WithStack(err)
+		}
+	case fs.ModeSymlink:
+		link, err := os.Readlink(src)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if err := os.Symlink(link, dst); err != nil {
+			return errors.WithStack(err)
+		}
+	default:
+		// Device nodes, fifos and sockets.
+		if err := unix.Mknod(dst, stat.Mode, int(stat.Rdev)); err != nil {
+			return errors.Wrapf(err, "creating special file '%s' failed", dst)
+		}
+	}
+
+	uid, gid := 0, 0
+	if c.preserveOwnership {
+		uid, gid = int(stat.Uid), int(stat.Gid)
+	}
+	if err := os.Lchown(dst, uid, gid); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if info.Mode().Type() != fs.ModeSymlink {
+		// Mode is set after changing the owner because chown clears setuid and setgid bits.
+		if err := unix.Chmod(dst, stat.Mode&07777); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	// Extended attributes are copied after changing the owner because chown clears file capabilities.
+	if c.preserveOwnership {
+		if err := copyXattrs(src, dst); err != nil {
+			return err
+		}
+	}
+
+	return setTimes(dst, info)
+}
+
+func copyFile(src, dst string) error {
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer srcFile.Close()
+
+	dstFile, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer dstFile.Close()
+
+	if _, err := io.Copy(dstFile, srcFile); err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(dstFile.Close())
+}
+
+func copyXattrs(src, dst string) error {
+	size, err := unix.Llistxattr(src, nil)
+	if err != nil {
+		if errors.Is(err, unix.ENOTSUP) {
+			return nil
+		}
+		return errors.WithStack(err)
+	}
+	if size == 0 {
+		return nil
+	}
+
+	buf := make([]byte, size)
+	size, err = unix.Llistxattr(src, buf)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	for _, name := range strings.Split(strings.TrimSuffix(string(buf[:size]), "\x00"), "\x00") {
+		valueSize, err := unix.Lgetxattr(src, name, nil)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		value := make([]byte, valueSize)
+		valueSize, err = unix.Lgetxattr(src, name, value)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if err := unix.Lsetxattr(dst, name, value[:valueSize], 0); err != nil {
+			return errors.Wrapf(err, "setting extended attribute '%s' on '%s' failed", name, dst)
+		}
+	}
+	return nil
+}
+
+func setTimes(path string, info fs.FileInfo) error {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return errors.Errorf("unsupported file info of '%s'", path)
+	}
+	return errors.WithStack(unix.UtimesNanoAt(unix.AT_FDCWD, path, []unix.Timespec{
+		unix.NsecToTimespec(syscall.TimespecToNsec(stat.Atim)),
+		unix.NsecToTimespec(syscall.TimespecToNsec(stat.Mtim)),
+	}, unix.AT_SYMLINK_NOFOLLOW))
+}
@@ -0,0 +1,179 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/outofforest/isolator/wire"
+	"github.com/outofforest/libexec"
+	"github.com/outofforest/logger"
+)
+
+// notStartedExitCode is the exit code reported if command can't be started.
+const notStartedExitCode = 127
+
+// ExecuteHandler handles Execute command inside isolator.
+func ExecuteHandler(ctx context.Context, content interface{}, encode wire.EncoderFunc) error {
+	m, ok := content.(Execute)
+	if !ok {
+		return errors.Errorf("unexpected type %T", content)
+	}
+	if len(m.Args) == 0 {
+		return errors.New("no command to execute")
+	}
+
+	outTransmitter := newLogTransmitter(encode)
+	errTransmitter := newLogTransmitter(encode)
+
+	cmd := exec.Command(m.Args[0], m.Args[1:]...)
+	cmd.Stdout = outTransmitter
+	cmd.Stderr = errTransmitter
+	if m.Stream {
+		cmd.Stdout = newOutputTransmitter(encode, false)
+		cmd.Stderr = newOutputTransmitter(encode, true)
+	}
+	cmd.Env = append(os.Environ(), m.Env...)
+
+	if m.WorkDir != "" {
+		if err := os.MkdirAll(m.WorkDir, 0o755); err != nil {
+			return errors.WithStack(err)
+		}
+		cmd.Dir = m.WorkDir
+	}
+	// Command runs in its own process group, so the whole tree might be killed on timeout.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if m.TTY != "" {
+		tty, err := os.OpenFile(m.TTY, os.O_RDWR, 0)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		defer tty.Close()
+
+		cmd.Stdin = tty
+		cmd.Stdout = tty
+		cmd.Stderr = tty
+
+		// New session is created to make terminal the controlling one, session leader is the group leader too.
+		cmd.SysProcAttr = &syscall.SysProcAttr{
+			Setsid:  true,
+			Setctty: true,
+			Ctty:    0,
+		}
+	}
+	if m.User != nil {
+		cmd.SysProcAttr.Credential = &syscall.Credential{
+			Uid:    m.User.UID,
+			Gid:    m.User.GID,
+			Groups: m.User.Groups,
+		}
+		cmd.Env = append(cmd.Env, "USER="+m.User.Name, "HOME="+m.User.Home)
+	}
+	if m.IsolateNetwork {
+		cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWNET
+	}
+
+	unmount, err := applyMounts(m.Mounts)
+	if err != nil {
+		return err
+	}
+	unmountSecrets, err := applySecrets(m.Secrets)
+	if err != nil {
+		_ = unmount()
+		return err
+	}
+
+	log := logger.Get(ctx)
+	log.Info("Starting command", zap.Strings("args", m.Args))
+
+	err = execute(ctx, cmd, m.Timeout)
+
+	// In stream mode non-zero exit code is not an error, it is passed to the client instead.
+	exited := m.Stream && cmd.ProcessState != nil && cmd.ProcessState.Exited() && ctx.Err() == nil
+	exitCode := 0
+	switch {
+	case exited:
+		exitCode = cmd.ProcessState.ExitCode()
+		err = nil
+	case m.Stream && err != nil && cmd.Process == nil && ctx.Err() == nil:
+		// Command which couldn't be started exits with 127, like in shells. Reason is printed to standard error.
+		exited = true
+		exitCode = notStartedExitCode
+		err = encode(Output{Stderr: true, Content: []byte(err.Error() + "\n")})
+	}
+	if err2 := outTransmitter.Flush(); err2 != nil && err == nil {
+		err = err2
+	}
+	if err2 := errTransmitter.Flush(); err2 != nil && err == nil {
+		err = err2
+	}
+	if err2 := unmountSecrets(); err2 != nil && err == nil {
+		err = err2
+	}
+	if err2 := unmount(); err2 != nil && err == nil {
+		err = err2
+	}
+	if exited && err == nil {
+		err = encode(Exited{Code: exitCode})
+	}
+	if err != nil {
+		log.Error("Command exited with error", zap.Error(err))
+		return err
+	}
+
+	log.Info("Command exited")
+	return nil
+}
+
+func execute(ctx context.Context, cmd *exec.Cmd, timeout time.Duration) error {
+	if timeout == 0 {
+		return libexec.Exec(ctx, cmd)
+	}
+
+	// libexec signals only the main process and waits for it, so processes ignoring SIGTERM and their children
+	// would block it forever. That's why command with timeout is supervised here.
+	cmd.Stdin = bytes.NewReader(nil)
+	existing := processes()
+	if err := cmd.Start(); err != nil {
+		return errors.WithStack(err)
+	}
+
+	waitCh := make(chan error, 1)
+	go func() {
+		waitCh <- cmd.Wait()
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-waitCh:
+		return errors.WithStack(err)
+	case <-ctx.Done():
+		killTree(existing)
+		<-waitCh
+		return errors.WithStack(ctx.Err())
+	case <-timer.C:
+		killTree(existing)
+		<-waitCh
+		return errors.Errorf("command timed out after %s", timeout)
+	}
+}
+
+// PingHandler handles Ping command inside isolator.
+func PingHandler(ctx context.Context, content interface{}, encode wire.EncoderFunc) error {
+	if _, ok := content.(Ping); !ok {
+		return errors.Errorf("unexpected type %T", content)
+	}
+	pidNamespace, err := os.Readlink("/proc/self/ns/pid")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return encode(Started{PIDNamespace: pidNamespace})
+}
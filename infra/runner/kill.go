@@ -0,0 +1,49 @@
+package runner
+
+import (
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// processes returns PIDs of all the processes running in the namespace of the executor.
+func processes() map[int]bool {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil
+	}
+
+	res := map[int]bool{}
+	for _, e := range entries {
+		if pid, err := strconv.Atoi(e.Name()); err == nil {
+			res[pid] = true
+		}
+	}
+	return res
+}
+
+// killTree kills all the processes started by the command. Executor is the init process of the namespace
+// and runs one command at a time, so processes started by the command are the ones which didn't exist before.
+// Looking at the parent is not enough because processes leaving the session are reparented to the executor.
+// All the processes are stopped first, so none of them may start new ones or exit unnoticed, then they are killed.
+func killTree(existing map[int]bool) {
+	self := os.Getpid()
+	stopped := map[int]bool{}
+	for {
+		found := false
+		for pid := range processes() {
+			if pid == self || existing[pid] || stopped[pid] {
+				continue
+			}
+			_ = syscall.Kill(pid, syscall.SIGSTOP)
+			stopped[pid] = true
+			found = true
+		}
+		if !found {
+			break
+		}
+	}
+	for pid := range stopped {
+		_ = syscall.Kill(pid, syscall.SIGKILL)
+	}
+}
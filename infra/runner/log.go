@@ -0,0 +1,56 @@
+package runner
+
+import (
+	"bytes"
+	"sync"
+	"time"
+
+	"github.com/outofforest/isolator/wire"
+)
+
+func newLogTransmitter(encode wire.EncoderFunc) *logTransmitter {
+	return &logTransmitter{
+		encode: encode,
+	}
+}
+
+// logTransmitter splits output of the command into lines and sends them as log messages.
+type logTransmitter struct {
+	encode wire.EncoderFunc
+
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (lt *logTransmitter) Write(data []byte) (int, error) {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+
+	lt.buf = append(lt.buf, data...)
+	for {
+		pos := bytes.IndexByte(lt.buf, '\n')
+		if pos < 0 {
+			break
+		}
+		if pos > 0 {
+			if err := lt.encode(wire.Log{Time: time.Now().UTC(), Content: lt.buf[:pos]}); err != nil {
+				return 0, err
+			}
+		}
+		lt.buf = lt.buf[pos+1:]
+	}
+	return len(data), nil
+}
+
+// Flush sends the remaining content not terminated by new line.
+func (lt *logTransmitter) Flush() error {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+
+	if len(lt.buf) == 0 {
+		return nil
+	}
+	err := lt.encode(wire.Log{Time: time.Now().UTC(), Content: lt.buf})
+	lt.buf = nil
+	return err
+}
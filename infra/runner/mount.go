@@ -0,0 +1,153 @@
+package runner
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// applyMounts binds mounts to their targets and returns the function unmounting them.
+// Mountpoints not existing before are removed after unmounting, so they don't land in the image.
+func applyMounts(mounts []Mount) (func() error, error) {
+	mounted := make([]string, 0, len(mounts))
+	var created []string
+	unmount := func() error {
+		var retErr error
+		for i := len(mounted) - 1; i >= 0; i-- {
+			if err := syscall.Unmount(mounted[i], syscall.MNT_DETACH); err != nil && retErr == nil {
+				retErr = errors.WithStack(err)
+			}
+		}
+		for i := len(created) - 1; i >= 0; i-- {
+			if err := os.Remove(created[i]); err != nil && !os.IsNotExist(err) && retErr == nil {
+				retErr = errors.WithStack(err)
+			}
+		}
+		return retErr
+	}
+
+	for _, m := range mounts {
+		paths, err := createMountpoint(m)
+		created = append(created, paths...)
+		if err != nil {
+			_ = unmount()
+			return nil, err
+		}
+		if err := bindMount(m); err != nil {
+			_ = unmount()
+			return nil, err
+		}
+		mounted = append(mounted, m.Target)
+	}
+	return unmount, nil
+}
+
+// secretsDir is the directory where tmpfs storing secrets is mounted while they are bound to their targets.
+const secretsDir = "/.secrets"
+
+// applySecrets writes secrets to tmpfs and binds them to their targets. Tmpfs is detached right after,
+// so command sees only the secrets bound to their targets.
+func applySecrets(secrets []Secret) (retUnmount func() error, retErr error) {
+	if len(secrets) == 0 {
+		return func() error { return nil }, nil
+	}
+
+	if err := os.Mkdir(secretsDir, 0o700); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer func() {
+		if err := os.Remove(secretsDir); err != nil && retErr == nil {
+			retErr = errors.WithStack(err)
+		}
+	}()
+	if err := syscall.Mount("tmpfs", secretsDir, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC,
+		"mode=0700"); err != nil {
+		return nil, errors.Wrap(err, "mounting tmpfs for secrets failed")
+	}
+	defer func() {
+		if err := syscall.Unmount(secretsDir, syscall.MNT_DETACH); err != nil && retErr == nil {
+			retErr = errors.WithStack(err)
+		}
+	}()
+
+	mounts := make([]Mount, 0, len(secrets))
+	for i, s := range secrets {
+		file := filepath.Join(secretsDir, strconv.Itoa(i))
+		if err := os.WriteFile(file, s.Content, 0o400); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if err := os.Chown(file, int(s.UID), int(s.GID)); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if err := os.Chmod(file, s.Mode); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		mounts = append(mounts, Mount{
+			Source: file,
+			Target: s.Target,
+		})
+	}
+	return applyMounts(mounts)
+}
+
+// createMountpoint creates the mountpoint of the same type as the source and returns the list of created paths.
+func createMountpoint(m Mount) ([]string, error) {
+	info, err := os.Stat(m.Source)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var missing []string
+	for dir := filepath.Dir(m.Target); ; dir = filepath.Dir(dir) {
+		if _, err := os.Lstat(dir); err == nil {
+			break
+		} else if !os.IsNotExist(err) {
+			return nil, errors.WithStack(err)
+		}
+		missing = append(missing, dir)
+	}
+
+	var created []string
+	for i := len(missing) - 1; i >= 0; i-- {
+		if err := os.Mkdir(missing[i], 0o755); err != nil {
+			return created, errors.WithStack(err)
+		}
+		created = append(created, missing[i])
+	}
+
+	if info.IsDir() {
+		err = os.Mkdir(m.Target, 0o755)
+	} else {
+		var f *os.File
+		f, err = os.OpenFile(m.Target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o400)
+		if err == nil {
+			err = f.Close()
+		}
+	}
+	switch {
+	case err == nil:
+		created = append(created, m.Target)
+	case os.IsExist(err):
+	default:
+		return created, errors.WithStack(err)
+	}
+	return created, nil
+}
+
+func bindMount(m Mount) error {
+	if err := syscall.Mount(m.Source, m.Target, "", syscall.MS_BIND|syscall.MS_PRIVATE, ""); err != nil {
+		return errors.WithStack(err)
+	}
+	if !m.Writable {
+		// To mount readonly, mount has to be remounted using read-only option.
+		if err := syscall.Mount(m.Source, m.Target, "",
+			syscall.MS_BIND|syscall.MS_PRIVATE|syscall.MS_REMOUNT|syscall.MS_RDONLY, ""); err != nil {
+			_ = syscall.Unmount(m.Target, syscall.MNT_DETACH)
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+package runner
+
+import (
+	"github.com/outofforest/isolator/wire"
+)
+
+func newOutputTransmitter(encode wire.EncoderFunc, stderr bool) *outputTransmitter {
+	return &outputTransmitter{
+		encode: encode,
+		stderr: stderr,
+	}
+}
+
+// outputTransmitter sends raw output of the command without splitting it into lines.
+type outputTransmitter struct {
+	encode wire.EncoderFunc
+	stderr bool
+}
+
+func (ot *outputTransmitter) Write(data []byte) (int, error) {
+	if err := ot.encode(Output{Stderr: ot.stderr, Content: data}); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
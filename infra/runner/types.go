@@ -0,0 +1,141 @@
+package runner
+
+import (
+	"os"
+	"time"
+)
+
+// Execute is sent to execute a command inside the build.
+type Execute struct {
+	// Args is the command to execute together with its arguments.
+	Args []string
+
+	// WorkDir is the working directory of the command.
+	WorkDir string
+
+	// User is the user command is executed as. If nil, command is executed as root.
+	User *User
+
+	// IsolateNetwork runs command in a new network namespace having no access to the network.
+	IsolateNetwork bool
+
+	// Mounts is the list of mounts applied for the time of command execution.
+	Mounts []Mount
+
+	// Secrets is the list of secret files available to the command.
+	Secrets []Secret
+
+	// Env is the list of additional environment variables in the form of KEY=VALUE.
+	Env []string
+
+	// TTY is the path of the terminal inside the build. If set, it is used as standard input and outputs of the
+	// command, and becomes its controlling terminal.
+	TTY string
+
+	// Stream sends raw output and the exit code of the command using Output and Exited messages, instead of
+	// log lines and error.
+	Stream bool
+
+	// Timeout is the maximum time command may run. If exceeded, command and all its children are killed.
+	// Zero means no timeout.
+	Timeout time.Duration
+}
+
+// Mount defines directory bound to the location inside the build.
+type Mount struct {
+	// Source is the path of mounted directory inside namespace.
+	Source string
+
+	// Target is the path where source is mounted.
+	Target string
+
+	// Writable makes mount writable.
+	Writable bool
+}
+
+// Secret defines secret file mounted for the time of command execution.
+type Secret struct {
+	// Content is the content of the secret.
+	Content []byte
+
+	// Target is the path where secret is mounted.
+	Target string
+
+	// UID is the owner of the file.
+	UID uint32
+
+	// GID is the group of the file.
+	GID uint32
+
+	// Mode is the permission mode of the file.
+	Mode os.FileMode
+}
+
+// User defines credentials used to execute a command.
+type User struct {
+	// Name is the name of the user.
+	Name string
+
+	// Home is the home directory of the user.
+	Home string
+
+	// UID is the user ID.
+	UID uint32
+
+	// GID is the primary group ID.
+	GID uint32
+
+	// Groups is the list of supplementary group IDs.
+	Groups []uint32
+}
+
+// Ping is sent to start the executor before any command is executed.
+type Ping struct{}
+
+// Started is sent by executor in response to Ping, so its process might be identified on the host.
+type Started struct {
+	// PIDNamespace is the PID namespace of the executor, in the form of /proc/<pid>/ns/pid link.
+	PIDNamespace string
+}
+
+// Output is sent by executor to pass raw output of the command executed in stream mode.
+type Output struct {
+	// Stderr is true if content was printed to standard error.
+	Stderr bool
+
+	// Content is the printed content.
+	Content []byte
+}
+
+// Exited is sent by executor when command executed in stream mode exits.
+type Exited struct {
+	// Code is the exit code of the command.
+	Code int
+}
+
+// Copy is sent to copy files inside the build.
+type Copy struct {
+	// Root is the directory sources are located in. It is used to report paths relative to it.
+	Root string
+
+	// Sources are the absolute paths of copied files. Glob patterns are accepted.
+	Sources []string
+
+	// Dest is the absolute destination path. If it ends with /, files are copied into that directory.
+	Dest string
+
+	// PreserveOwnership preserves owners and extended attributes of the files. Otherwise, files are owned by root.
+	PreserveOwnership bool
+}
+
+// Unpack is sent to unpack tar archive into the root of the build.
+type Unpack struct {
+	// Archive is the path of the tar archive. Archives compressed using gzip or zstd are detected automatically.
+	Archive string
+
+	// Whiteouts enables OCI whiteout files removing content created by previously unpacked layers.
+	Whiteouts bool
+
+	// Digest is the expected sha256 digest of the archive. If set, it is verified once archive is unpacked.
+	Digest string
+}
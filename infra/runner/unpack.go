@@ -0,0 +1,269 @@
+package runner
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+
+	"github.com/outofforest/isolator/wire"
+)
+
+const (
+	whiteoutPrefix     = ".wh."
+	whiteoutMetaPrefix = ".wh..wh."
+	whiteoutOpaque     = ".wh..wh..opq"
+	xattrPAXPrefix     = "SCHILY.xattr."
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// UnpackHandler handles Unpack command inside isolator.
+func UnpackHandler(ctx context.Context, content interface{}, encode wire.EncoderFunc) error {
+	m, ok := content.(Unpack)
+	if !ok {
+		return errors.Errorf("unexpected type %T", content)
+	}
+
+	f, err := os.Open(m.Archive)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer f.Close()
+
+	var archive io.Reader = f
+	hasher := sha256.New()
+	if m.Digest != "" {
+		archive = io.TeeReader(f, hasher)
+	}
+
+	r, err := decompress(archive)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	if err := unpack(ctx, tar.NewReader(r), m.Whiteouts); err != nil {
+		return err
+	}
+	if m.Digest == "" {
+		return nil
+	}
+
+	// Data after the end of the archive is hashed too.
+	if _, err := io.Copy(io.Discard, archive); err != nil {
+		return errors.WithStack(err)
+	}
+	if digest := "sha256:" + hex.EncodeToString(hasher.Sum(nil)); digest != m.Digest {
+		return errors.Errorf("digest of archive %s doesn't match, expected: %s, got: %s", m.Archive, m.Digest, digest)
+	}
+	return nil
+}
+
+// decompress returns reader decompressing the archive if it is compressed.
+func decompress(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(len(zstdMagic))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, errors.WithStack(err)
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return gr, nil
+	case bytes.HasPrefix(magic, zstdMagic):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return zr.IOReadCloser(), nil
+	default:
+		return io.NopCloser(br), nil
+	}
+}
+
+func unpack(ctx context.Context, tr *tar.Reader, whiteouts bool) error {
+	// Paths created by the archive itself aren't removed by opaque whiteouts.
+	added := map[string]bool{}
+	// Modification times of directories are set at the end because they are changed by creating their content.
+	var dirs []*tar.Header
+	for {
+		if err := ctx.Err(); err != nil {
+			return errors.WithStack(err)
+		}
+
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		// Archive is unpacked inside the build root, so it's not possible to go outside of it.
+		path := filepath.Join("/", header.Name)
+		if path == "/" {
+			// Attributes of the root directory are set by the builder.
+			continue
+		}
+
+		name := filepath.Base(path)
+		if whiteouts && strings.HasPrefix(name, whiteoutPrefix) {
+			if err := applyWhiteout(path, added); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := unpackEntry(tr, header, path); err != nil {
+			return err
+		}
+		added[path] = true
+		if header.Typeflag == tar.TypeDir {
+			dirs = append(dirs, header)
+		}
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := setHeaderTimes(filepath.Join("/", dirs[i].Name), dirs[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyWhiteout removes the content of previous layers hidden by the whiteout file.
+func applyWhiteout(path string, added map[string]bool) error {
+	dir, name := filepath.Split(path)
+	switch {
+	case name == whiteoutOpaque:
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return errors.WithStack(err)
+		}
+		for _, e := range entries {
+			p := filepath.Join(dir, e.Name())
+			if added[p] {
+				continue
+			}
+			if err := os.RemoveAll(p); err != nil {
+				return errors.WithStack(err)
+			}
+		}
+		return nil
+	case strings.HasPrefix(name, whiteoutMetaPrefix):
+		// Other special files, like hardlink directories of aufs, are ignored.
+		return nil
+	default:
+		return errors.WithStack(os.RemoveAll(filepath.Join(dir, strings.TrimPrefix(name, whiteoutPrefix))))
+	}
+}
+
+func unpackEntry(tr *tar.Reader, header *tar.Header, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return errors.WithStack(err)
+	}
+
+	// Existing entry is replaced unless both are directories, then their content is merged.
+	if existing, err := os.Lstat(path); err == nil && !(existing.IsDir() && header.Typeflag == tar.TypeDir) {
+		if err := os.RemoveAll(path); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	switch header.Typeflag {
+	case tar.TypeReg:
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		_, err = io.Copy(f, tr)
+		if err2 := f.Close(); err == nil {
+			err = err2
+		}
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	case tar.TypeDir:
+		if err := os.Mkdir(path, 0o700); err != nil && !os.IsExist(err) {
+			return errors.WithStack(err)
+		}
+	case tar.TypeSymlink:
+		if err := os.Symlink(header.Linkname, path); err != nil {
+			return errors.WithStack(err)
+		}
+	case tar.TypeLink:
+		// Hard link shares attributes with its target, so there is nothing more to do.
+		return errors.WithStack(os.Link(filepath.Join("/", header.Linkname), path))
+	case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+		mode := map[byte]uint32{
+			tar.TypeChar:  unix.S_IFCHR,
+			tar.TypeBlock: unix.S_IFBLK,
+			tar.TypeFifo:  unix.S_IFIFO,
+		}[header.Typeflag]
+		dev := int(unix.Mkdev(uint32(header.Devmajor), uint32(header.Devminor)))
+		if err := unix.Mknod(path, mode|uint32(header.Mode&07777), dev); err != nil {
+			return errors.Wrapf(err, "creating special file '%s' failed", path)
+		}
+	default:
+		return errors.Errorf("unsupported type %q of '%s'", header.Typeflag, header.Name)
+	}
+
+	if err := os.Lchown(path, header.Uid, header.Gid); err != nil {
+		return errors.WithStack(err)
+	}
+	if header.Typeflag != tar.TypeSymlink {
+		// Mode is set after changing the owner because chown clears setuid and setgid bits.
+		if err := unix.Chmod(path, uint32(header.Mode&07777)); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	// Extended attributes are set after changing the owner because chown clears file capabilities.
+	for key, value := range header.PAXRecords {
+		if !strings.HasPrefix(key, xattrPAXPrefix) {
+			continue
+		}
+		name := strings.TrimPrefix(key, xattrPAXPrefix)
+		if err := unix.Lsetxattr(path, name, []byte(value), 0); err != nil {
+			return errors.Wrapf(err, "setting extended attribute '%s' on '%s' failed", name, path)
+		}
+	}
+
+	if header.Typeflag == tar.TypeDir {
+		return nil
+	}
+	return setHeaderTimes(path, header)
+}
+
+func setHeaderTimes(path string, header *tar.Header) error {
+	atime := header.AccessTime
+	if atime.IsZero() {
+		atime = header.ModTime
+	}
+	return errors.WithStack(unix.UtimesNanoAt(unix.AT_FDCWD, path, []unix.Timespec{
+		unix.NsecToTimespec(atime.UnixNano()),
+		unix.NsecToTimespec(header.ModTime.UnixNano()),
+	}, unix.AT_SYMLINK_NOFOLLOW))
+}
@@ -0,0 +1,103 @@
+package infra
+
+import (
+	"bytes"
+	"os"
+	"sort"
+
+	"github.com/pkg/errors"
+
+	"github.com/outofforest/osman/infra/description"
+	"github.com/outofforest/osman/infra/runner"
+)
+
+const (
+	minMaskedLength = 4
+
+	// defaultSecretMode is the permission mode of secret file if not specified by the mount.
+	defaultSecretMode = 0o400
+)
+
+var secretMask = []byte("***")
+
+// newSecretStore reads secrets. They are kept in memory and passed to the executor only for commands
+// requesting them, so they are never stored on disk together with the build.
+func newSecretStore(secrets map[string]string) (*secretStore, error) {
+	store := &secretStore{
+		secrets: make(map[string][]byte, len(secrets)),
+	}
+	for id, src := range secrets {
+		content, err := os.ReadFile(src)
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading secret %s failed", id)
+		}
+		store.secrets[id] = content
+		for _, line := range bytes.Split(content, []byte{'\n'}) {
+			if line = bytes.TrimSpace(line); len(line) >= minMaskedLength {
+				store.masks = append(store.masks, line)
+			}
+		}
+	}
+	return store, nil
+}
+
+type secretStore struct {
+	secrets map[string][]byte
+	masks   [][]byte
+}
+
+// Secret returns secret requested by the mount. If uid and gid are not specified by the mount,
+// secret is owned by the user.
+func (s *secretStore) Secret(m description.Mount, user *runner.User) (runner.Secret, error) {
+	content, exists := s.secrets[m.ID]
+	if !exists {
+		return runner.Secret{}, errors.Errorf("secret %s is not provided", m.ID)
+	}
+
+	secret := runner.Secret{
+		Content: content,
+		Target:  m.Target,
+		Mode:    m.Mode,
+	}
+	if user != nil {
+		secret.UID = user.UID
+		secret.GID = user.GID
+	}
+	if m.UID != nil {
+		secret.UID = *m.UID
+	}
+	if m.GID != nil {
+		secret.GID = *m.GID
+	}
+	if secret.Mode == 0 {
+		secret.Mode = defaultSecretMode
+	}
+	return secret, nil
+}
+
+// All returns all the secrets mounted in their default locations and owned by root.
+func (s *secretStore) All() []runner.Secret {
+	ids := make([]string, 0, len(s.secrets))
+	for id := range s.secrets {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	secrets := make([]runner.Secret, 0, len(ids))
+	for _, id := range ids {
+		secrets = append(secrets, runner.Secret{
+			Content: s.secrets[id],
+			Target:  description.SecretMount(id, "").Target,
+			Mode:    defaultSecretMode,
+		})
+	}
+	return secrets
+}
+
+// Mask replaces secrets in the content.
+func (s *secretStore) Mask(content []byte) []byte {
+	for _, m := range s.masks {
+		content = bytes.ReplaceAll(content, m, secretMask)
+	}
+	return content
+}
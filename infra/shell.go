@@ -0,0 +1,141 @@
+package infra
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/creack/pty"
+	"github.com/pkg/errors"
+	"golang.org/x/term"
+
+	"github.com/outofforest/isolator"
+	"github.com/outofforest/isolator/wire"
+	"github.com/outofforest/osman/config"
+	"github.com/outofforest/osman/infra/cache"
+	"github.com/outofforest/osman/infra/runner"
+)
+
+// ttyMountpoint is the path where terminal is mounted inside the build.
+const ttyMountpoint = "/.tty"
+
+// Shell opens interactive shell inside the build stored in path. Mounts are the same as the ones used by builder.
+func Shell(ctx context.Context, path string, shell config.Shell) (retErr error) {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return errors.New("shell requires standard input to be a terminal")
+	}
+
+	buildCache := cache.New(shell.CacheDir)
+	if err := os.MkdirAll(buildCache.Dir(), 0o700); err != nil {
+		return errors.WithStack(err)
+	}
+
+	secrets, err := newSecretStore(shell.Secrets)
+	if err != nil {
+		return err
+	}
+
+	ptyMaster, ptySlave, err := pty.Open()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer ptyMaster.Close()
+	defer ptySlave.Close()
+
+	defer func() {
+		if err := removeMountpoints(path, ttyMountpoint); err != nil && retErr == nil {
+			retErr = err
+		}
+	}()
+
+	if err := pty.InheritSize(os.Stdin, ptyMaster); err != nil {
+		return errors.WithStack(err)
+	}
+	resizeCh := make(chan os.Signal, 1)
+	signal.Notify(resizeCh, syscall.SIGWINCH)
+	defer signal.Stop(resizeCh)
+	go func() {
+		for range resizeCh {
+			_ = pty.InheritSize(os.Stdin, ptyMaster)
+		}
+	}()
+
+	state, err := term.MakeRaw(int(os.Stdin.Fd()))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer func() {
+		_ = term.Restore(int(os.Stdin.Fd()), state)
+	}()
+
+	// Copying from stdin never finishes, it is abandoned when shell exits.
+	go func() {
+		_, _ = io.Copy(ptyMaster, os.Stdin)
+	}()
+	outputDone := make(chan struct{})
+	go func() {
+		defer close(outputDone)
+		_, _ = io.Copy(os.Stdout, ptyMaster)
+	}()
+
+	args := shell.Command
+	if len(args) == 0 {
+		args = []string{"/bin/sh"}
+		if _, err := os.Stat(filepath.Join(path, "bin", "bash")); err == nil {
+			args = []string{"/bin/bash"}
+		}
+	}
+
+	err = isolator.Run(ctx, isolator.Config{
+		Dir: path,
+		Types: []interface{}{
+			wire.Result{},
+			wire.Log{},
+		},
+		Executor: wire.Config{
+			ConfigureSystem: true,
+			UseHostNetwork:  true,
+			Mounts: append(builderMounts(buildCache), wire.Mount{
+				Host:      ptySlave.Name(),
+				Namespace: ttyMountpoint,
+				Writable:  true,
+			}),
+		},
+	}, func(ctx context.Context, incoming <-chan interface{}, outgoing chan<- interface{}) error {
+		select {
+		case <-ctx.Done():
+			return errors.WithStack(ctx.Err())
+		case outgoing <- runner.Execute{
+			Args:           args,
+			WorkDir:        "/",
+			IsolateNetwork: shell.Network == config.NetworkNone,
+			Env:            []string{"TERM=" + os.Getenv("TERM")},
+			Secrets:        secrets.All(),
+			TTY:            ttyMountpoint,
+		}:
+		}
+
+		for content := range incoming {
+			switch m := content.(type) {
+			case wire.Log:
+			case wire.Result:
+				if m.Error != "" {
+					return errors.Errorf("shell exited with error: %s", m.Error)
+				}
+				return nil
+			default:
+				return errors.New("unexpected message received")
+			}
+		}
+		return errors.WithStack(ctx.Err())
+	})
+
+	// Once all the descriptors of the terminal are closed, remaining output is flushed and copying finishes.
+	_ = ptySlave.Close()
+	<-outputDone
+
+	return err
+}
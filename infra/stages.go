@@ -0,0 +1,206 @@
+package infra
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"strconv"
+
+	"github.com/pkg/errors"
+
+	"github.com/outofforest/isolator/wire"
+	"github.com/outofforest/osman/infra/buildlog"
+	"github.com/outofforest/osman/infra/description"
+	"github.com/outofforest/osman/infra/types"
+)
+
+// stagesMountpoint is the directory where builds files are copied from are mounted.
+const stagesMountpoint = "/.stages"
+
+// registerStages splits multi-stage image into build stages. All the stages except the last one are stored in
+// repository as images named <image>.<stage>, and references to them are replaced by their build keys.
+// Stages are built only if the last stage depends on them. Build keys of stages are returned in the order
+// of definition.
+func (b *Builder) registerStages(
+	img *description.Descriptor,
+	tag types.Tag,
+) (*description.Descriptor, []types.BuildKey, error) {
+	var groups [][]description.Command
+	for _, cmd := range img.Commands() {
+		if _, ok := cmd.(*description.FromCommand); ok || len(groups) == 0 {
+			groups = append(groups, nil)
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], cmd)
+	}
+	if len(groups) < 2 {
+		return img, nil, nil
+	}
+
+	keys := make([]types.BuildKey, 0, len(groups)-1)
+	stageKeys := map[string]types.BuildKey{}
+	for i, group := range groups[:len(groups)-1] {
+		from, ok := group[0].(*description.FromCommand)
+		if !ok {
+			return nil, nil, errors.New("first command must be FROM")
+		}
+		name := from.Stage
+		if name == "" {
+			name = strconv.Itoa(i)
+		}
+		if _, exists := stageKeys[name]; exists {
+			return nil, nil, errors.Errorf("stage %s is defined more than once", name)
+		}
+
+		key := types.NewBuildKey(img.Name()+"."+name, tag)
+		b.repo.Store(description.Describe(key.Name, types.Tags{tag}, replaceStages(group, stageKeys)...))
+		b.stages[key] = true
+		stageKeys[name] = key
+		keys = append(keys, key)
+	}
+
+	return description.Describe(img.Name(), img.Tags(), replaceStages(groups[len(groups)-1], stageKeys)...), keys, nil
+}
+
+// dropStages drops builds of stages and their logs once the image is built. Stages image is based on can't be
+// dropped, so they are untagged and kept as intermediate builds. Later stages may be based on earlier ones,
+// so they are processed in the reverse order.
+func (b *Builder) dropStages(
+	ctx context.Context,
+	cacheDir string,
+	buildID types.BuildID,
+	keys []types.BuildKey,
+) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	// Failed image might be dropped already.
+	builds, err := b.storage.Builds(ctx)
+	if err != nil {
+		return err
+	}
+	ancestors := map[types.BuildID]bool{}
+	if slices.Contains(builds, buildID) {
+		for id := buildID; id != ""; {
+			info, err := b.storage.Info(ctx, id)
+			if err != nil {
+				return err
+			}
+			ancestors[id] = true
+			id = info.BasedOn
+		}
+	}
+
+	for i := len(keys) - 1; i >= 0; i-- {
+		key := keys[i]
+		if !b.readyBuilds[key] {
+			continue
+		}
+		delete(b.readyBuilds, key)
+
+		stageID, err := b.storage.BuildID(ctx, key)
+		if err != nil {
+			return err
+		}
+		if ancestors[stageID] {
+			if err := b.storage.Untag(ctx, stageID, key.Tag); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := b.storage.Drop(ctx, stageID); err != nil {
+			return err
+		}
+		if err := buildlog.Remove(cacheDir, stageID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replaceStages replaces references to build stages by their build keys.
+func replaceStages(commands []description.Command, stageKeys map[string]types.BuildKey) []description.Command {
+	res := make([]description.Command, 0, len(commands))
+	for _, cmd := range commands {
+		var newCmd description.Command
+		switch c := cmd.(type) {
+		case *description.FromCommand:
+			if key, exists := stageKeys[c.BuildKey.Name]; exists && c.BuildKey.Tag == description.DefaultTag {
+				newCmd = description.FromAs(key, c.Stage)
+			}
+		case *description.CopyCommand:
+			if key, exists := stageKeys[c.From]; exists {
+				newCmd = description.Copy(c.Sources, c.Dest, description.CopyFrom(key.String()))
+			}
+		}
+		if newCmd == nil {
+			res = append(res, cmd)
+			continue
+		}
+		description.Locate(newCmd, cmd.Source())
+		res = append(res, newCmd)
+	}
+	return res
+}
+
+// prepareCopySources resolves builds files are copied from by COPY commands, clones them and returns mounts
+// binding them inside the build. Returned function drops the clones.
+func (b *Builder) prepareCopySources(
+	ctx context.Context,
+	cacheDir string,
+	stack map[types.BuildKey]bool,
+	commands []description.Command,
+) (map[string]string, []wire.Mount, func() error, error) {
+	sources := map[string]string{}
+	var mounts []wire.Mount
+	var clones []types.BuildID
+	drop := func() error {
+		for _, buildID := range clones {
+			if err := b.storage.Drop(ctx, buildID); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, cmd := range commands {
+		copyCmd, ok := cmd.(*description.CopyCommand)
+		if !ok || copyCmd.From == "" {
+			continue
+		}
+		if _, exists := sources[copyCmd.From]; exists {
+			continue
+		}
+
+		buildKey, err := types.ParseBuildKey(copyCmd.From)
+		if err != nil {
+			_ = drop()
+			return nil, nil, nil, errors.WithMessagef(err, "invalid source of %s", copyCmd.Source())
+		}
+		if buildKey.Tag == "" {
+			buildKey.Tag = description.DefaultTag
+		}
+
+		srcBuildID, err := b.resolve(ctx, buildKey, cacheDir, stack)
+		if err != nil {
+			_ = drop()
+			return nil, nil, nil, err
+		}
+
+		buildID := types.NewBuildID(types.BuildTypeImage)
+		_, path, err := b.storage.Clone(ctx, srcBuildID, buildKey.Name, buildID)
+		if err != nil {
+			_ = drop()
+			return nil, nil, nil, err
+		}
+		clones = append(clones, buildID)
+
+		mountpoint := filepath.Join(stagesMountpoint, strconv.Itoa(len(mounts)))
+		mounts = append(mounts, wire.Mount{
+			Host:      path,
+			Namespace: mountpoint,
+		})
+		sources[copyCmd.From] = mountpoint
+	}
+	return sources, mounts, drop, nil
+}
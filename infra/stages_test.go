@@ -0,0 +1,70 @@
+package infra
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/outofforest/osman/infra/buildlog"
+	"github.com/outofforest/osman/infra/types"
+)
+
+func TestDropStages(t *testing.T) {
+	ctx := context.Background()
+	cacheDir := t.TempDir()
+	s := newMemoryStorage()
+
+	depsKey := types.NewBuildKey("app.deps", "latest")
+	buildKey := types.NewBuildKey("app.build", "latest")
+	depsID := s.add(depsKey.Name, types.Tags{depsKey.Tag}, "", nil)
+	buildID := s.add(buildKey.Name, types.Tags{buildKey.Tag}, "", nil)
+	imageID := s.add("app", types.Tags{"latest"}, buildID, nil)
+
+	for _, id := range []types.BuildID{depsID, buildID, imageID} {
+		log, err := buildlog.Create(cacheDir, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := log.Close(nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b := &Builder{
+		storage: s,
+		readyBuilds: map[types.BuildKey]bool{
+			depsKey:  true,
+			buildKey: true,
+		},
+	}
+	if err := b.dropStages(ctx, cacheDir, imageID, []types.BuildKey{depsKey, buildKey}); err != nil {
+		t.Fatal(err)
+	}
+
+	logExists := func(id types.BuildID) bool {
+		_, err := os.Stat(filepath.Join(buildlog.Dir(cacheDir), string(id)+".log"))
+		return err == nil
+	}
+
+	if _, exists := s.builds[depsID]; exists {
+		t.Error("stage image isn't based on should be dropped")
+	}
+	if logExists(depsID) {
+		t.Error("log of dropped stage should be removed")
+	}
+
+	info, exists := s.builds[buildID]
+	if !exists {
+		t.Fatal("stage image is based on should be kept")
+	}
+	if len(info.Tags) != 0 {
+		t.Errorf("stage image is based on should be untagged, tags: %v", info.Tags)
+	}
+	if !logExists(buildID) || !logExists(imageID) {
+		t.Error("logs of kept builds should be kept")
+	}
+	if len(b.readyBuilds) != 0 {
+		t.Errorf("dropped stages should not be ready anymore: %v", b.readyBuilds)
+	}
+}
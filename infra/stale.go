@@ -0,0 +1,170 @@
+package infra
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/outofforest/logger"
+	"github.com/outofforest/osman/infra/description"
+	"github.com/outofforest/osman/infra/storage"
+	"github.com/outofforest/osman/infra/types"
+)
+
+// NewStalenessChecker creates new checker detecting builds which are out of date.
+func NewStalenessChecker(storage storage.Driver) *StalenessChecker {
+	return &StalenessChecker{
+		storage: storage,
+		reasons: map[types.BuildID]string{},
+	}
+}
+
+// StalenessChecker detects builds which are out of date, because their spec file or parent image changed.
+type StalenessChecker struct {
+	storage storage.Driver
+	reasons map[types.BuildID]string
+}
+
+// Check returns the reason why build is out of date, or empty string if it is up to date.
+// Builds without recorded provenance, like base images, are never stale.
+func (c *StalenessChecker) Check(ctx context.Context, buildID types.BuildID) (string, error) {
+	if reason, exists := c.reasons[buildID]; exists {
+		return reason, nil
+	}
+
+	reason, err := c.check(ctx, buildID)
+	if err != nil {
+		return "", err
+	}
+	c.reasons[buildID] = reason
+	return reason, nil
+}
+
+func (c *StalenessChecker) check(ctx context.Context, buildID types.BuildID) (string, error) {
+	info, err := c.storage.Info(ctx, buildID)
+	if err != nil {
+		return "", err
+	}
+	p := info.Provenance
+	if p == nil {
+		return "", nil
+	}
+
+	if p.SpecFile != "" {
+		digest, err := specDigest(p.SpecFile, p.Includes)
+		switch {
+		case err == nil:
+			if digest != p.SpecDigest {
+				return fmt.Sprintf("spec file %s or files included by it changed", p.SpecFile), nil
+			}
+		case errors.Is(err, os.ErrNotExist):
+			// Image can't be rebuilt without its spec files, so it is not considered stale.
+		default:
+			return "", err
+		}
+	}
+
+	// Build stages are untagged once the image is built, so they are reached through the build image is based on.
+	parentID := info.BasedOn
+	if !p.FromStage {
+		parentID, err = c.storage.BuildID(ctx, p.From)
+		switch {
+		case err == nil:
+		case errors.Is(err, types.ErrImageDoesNotExist):
+			return fmt.Sprintf("parent %s does not exist", p.From), nil
+		default:
+			return "", err
+		}
+		if parentID != p.FromBuildID {
+			return fmt.Sprintf("parent %s was rebuilt", p.From), nil
+		}
+	}
+
+	parentReason, err := c.Check(ctx, parentID)
+	if err != nil {
+		return "", err
+	}
+	if parentReason != "" {
+		return fmt.Sprintf("parent %s is stale", p.From), nil
+	}
+	return "", nil
+}
+
+// rebuildIfStale rebuilds the image from its spec file if it is out of date and returns ID of the up-to-date build.
+func (b *Builder) rebuildIfStale(
+	ctx context.Context,
+	cacheDir string,
+	stack map[types.BuildKey]bool,
+	buildKey types.BuildKey,
+	buildID types.BuildID,
+) (types.BuildID, error) {
+	reason, err := b.staleness.Check(ctx, buildID)
+	if err != nil || reason == "" {
+		return buildID, err
+	}
+
+	info, err := b.storage.Info(ctx, buildID)
+	if err != nil {
+		return "", err
+	}
+	log := logger.Get(ctx).With(zap.Stringer("image", buildKey), zap.String("reason", reason))
+	if info.Provenance.SpecFile == "" {
+		log.Warn("Image is stale but it can't be rebuilt because it wasn't built from spec file")
+		return buildID, nil
+	}
+	log.Info("Image is stale, rebuilding")
+
+	return b.buildFromSpecDir(ctx, cacheDir, stack, info.Provenance.SpecFile, buildKey.Name, buildKey.Tag)
+}
+
+// upToDate returns ID of the existing build of the image if it is up to date with the spec file.
+func (b *Builder) upToDate(
+	ctx context.Context,
+	specFile, name string,
+	tags types.Tags,
+) (types.BuildID, bool, error) {
+	if specFile == "" {
+		return "", false, nil
+	}
+	if len(tags) == 0 {
+		tags = types.Tags{description.DefaultTag}
+	}
+
+	var buildID types.BuildID
+	for _, tag := range tags {
+		tagBuildID, err := b.storage.BuildID(ctx, types.NewBuildKey(name, tag))
+		switch {
+		case err == nil:
+		case errors.Is(err, types.ErrImageDoesNotExist):
+			return "", false, nil
+		default:
+			return "", false, err
+		}
+		if buildID != "" && tagBuildID != buildID {
+			return "", false, nil
+		}
+		buildID = tagBuildID
+	}
+
+	info, err := b.storage.Info(ctx, buildID)
+	if err != nil {
+		return "", false, err
+	}
+	if info.Provenance == nil || info.Provenance.SpecFile != specFile {
+		return "", false, nil
+	}
+	for k, v := range b.labels {
+		if value, exists := info.Labels[k]; !exists || value != v {
+			return "", false, nil
+		}
+	}
+
+	reason, err := b.staleness.Check(ctx, buildID)
+	if err != nil {
+		return "", false, err
+	}
+	return buildID, reason == "", nil
+}
@@ -0,0 +1,216 @@
+package infra
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/outofforest/osman/infra/parser"
+	"github.com/outofforest/osman/infra/storage"
+	"github.com/outofforest/osman/infra/types"
+)
+
+// memoryStorage keeps builds in memory. Operations on files are not supported.
+type memoryStorage struct {
+	storage.Driver
+
+	builds map[types.BuildID]types.BuildInfo
+}
+
+func newMemoryStorage() *memoryStorage {
+	return &memoryStorage{builds: map[types.BuildID]types.BuildInfo{}}
+}
+
+func (s *memoryStorage) add(name string, tags types.Tags, basedOn types.BuildID,
+	provenance *types.Provenance,
+) types.BuildID {
+	buildID := types.NewBuildID(types.BuildTypeImage)
+	s.builds[buildID] = types.BuildInfo{
+		BuildID:    buildID,
+		BasedOn:    basedOn,
+		Name:       name,
+		Tags:       tags,
+		Provenance: provenance,
+	}
+	return buildID
+}
+
+func (s *memoryStorage) Builds(ctx context.Context) ([]types.BuildID, error) {
+	builds := make([]types.BuildID, 0, len(s.builds))
+	for buildID := range s.builds {
+		builds = append(builds, buildID)
+	}
+	return builds, nil
+}
+
+func (s *memoryStorage) Info(ctx context.Context, buildID types.BuildID) (types.BuildInfo, error) {
+	info, exists := s.builds[buildID]
+	if !exists {
+		return types.BuildInfo{}, types.ErrImageDoesNotExist
+	}
+	return info, nil
+}
+
+func (s *memoryStorage) BuildID(ctx context.Context, buildKey types.BuildKey) (types.BuildID, error) {
+	for buildID, info := range s.builds {
+		if info.Name == buildKey.Name && slices.Contains(info.Tags, buildKey.Tag) {
+			return buildID, nil
+		}
+	}
+	return "", types.ErrImageDoesNotExist
+}
+
+func (s *memoryStorage) Untag(ctx context.Context, buildID types.BuildID, tag types.Tag) error {
+	info, exists := s.builds[buildID]
+	if !exists {
+		return types.ErrImageDoesNotExist
+	}
+	info.Tags = slices.DeleteFunc(slices.Clone(info.Tags), func(t types.Tag) bool { return t == tag })
+	s.builds[buildID] = info
+	return nil
+}
+
+func (s *memoryStorage) Drop(ctx context.Context, buildID types.BuildID) error {
+	if _, exists := s.builds[buildID]; !exists {
+		return types.ErrImageDoesNotExist
+	}
+	delete(s.builds, buildID)
+	return nil
+}
+
+const twoStageSpec = `FROM fedora@40 AS build
+RUN make
+
+FROM build
+RUN make install
+`
+
+// addTwoStageImage stores builds of the image built from two-stage spec, after the stage is untagged.
+func addTwoStageImage(t *testing.T, s *memoryStorage, specFile string) types.BuildID {
+	t.Helper()
+
+	baseKey := types.NewBuildKey("fedora", "40")
+	baseID := s.add(baseKey.Name, types.Tags{baseKey.Tag}, "", nil)
+
+	stageKey := types.NewBuildKey("app.build", "latest")
+	stageID := s.add(stageKey.Name, types.Tags{}, baseID, &types.Provenance{
+		From:        baseKey,
+		FromBuildID: baseID,
+	})
+
+	provenance, err := newProvenance(specFile, nil, stageKey, stageID, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s.add("app", types.Tags{"latest"}, stageID, provenance)
+}
+
+func TestStalenessTwoStages(t *testing.T) {
+	ctx := context.Background()
+	specFile := filepath.Join(t.TempDir(), "app.spec")
+	if err := os.WriteFile(specFile, []byte(twoStageSpec), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	s := newMemoryStorage()
+	buildID := addTwoStageImage(t, s, specFile)
+
+	reason, err := NewStalenessChecker(s).Check(ctx, buildID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reason != "" {
+		t.Fatalf("unchanged image should be fresh, reported: %s", reason)
+	}
+
+	if err := os.WriteFile(specFile, []byte(twoStageSpec+"RUN test\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	reason, err = NewStalenessChecker(s).Check(ctx, buildID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reason == "" {
+		t.Fatal("image should be stale once spec file changes")
+	}
+}
+
+func TestStalenessStageParentRebuilt(t *testing.T) {
+	ctx := context.Background()
+	specFile := filepath.Join(t.TempDir(), "app.spec")
+	if err := os.WriteFile(specFile, []byte(twoStageSpec), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	s := newMemoryStorage()
+	buildID := addTwoStageImage(t, s, specFile)
+
+	baseID, err := s.BuildID(ctx, types.NewBuildKey("fedora", "40"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Untag(ctx, baseID, "40"); err != nil {
+		t.Fatal(err)
+	}
+	s.add("fedora", types.Tags{"40"}, "", nil)
+
+	reason, err := NewStalenessChecker(s).Check(ctx, buildID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reason == "" {
+		t.Fatal("image should be stale once base image of the stage is rebuilt")
+	}
+}
+
+func TestStalenessIncludedFileChanged(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	includedFile := filepath.Join(dir, "included.spec")
+	specFile := filepath.Join(dir, "app.spec")
+	if err := os.WriteFile(includedFile, []byte("RUN make\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(specFile, []byte("FROM fedora@40\nINCLUDE "+includedFile+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	commands, err := parser.NewSpecFileParser().Parse(specFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	includes := specIncludes(specFile, commands)
+	if len(includes) != 1 || includes[0] != includedFile {
+		t.Fatalf("unexpected includes: %v", includes)
+	}
+
+	s := newMemoryStorage()
+	baseKey := types.NewBuildKey("fedora", "40")
+	baseID := s.add(baseKey.Name, types.Tags{baseKey.Tag}, "", nil)
+	provenance, err := newProvenance(specFile, includes, baseKey, baseID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buildID := s.add("app", types.Tags{"latest"}, baseID, provenance)
+
+	reason, err := NewStalenessChecker(s).Check(ctx, buildID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reason != "" {
+		t.Fatalf("unchanged image should be fresh, reported: %s", reason)
+	}
+
+	if err := os.WriteFile(includedFile, []byte("RUN make all\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	reason, err = NewStalenessChecker(s).Check(ctx, buildID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reason == "" {
+		t.Fatal("image should be stale once included file changes")
+	}
+}
@@ -6,7 +6,10 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/pkg/errors"
 	"github.com/ridge/must"
@@ -16,7 +19,12 @@ import (
 	"github.com/outofforest/osman/infra/types"
 )
 
-const propertyName = "co.exw:info"
+const (
+	propertyName = "co.exw:info"
+
+	// propertyLimit is the maximum length of the value stored in single property. ZFS limits user properties to 8KiB.
+	propertyLimit = 8000
+)
 
 // NewZFSDriver returns new storage driver based on zfs datasets.
 func NewZFSDriver(config config.Storage) Driver {
@@ -58,14 +66,10 @@ func (d *zfsDriver) Info(ctx context.Context, buildID types.BuildID) (types.Buil
 		return types.BuildInfo{}, err
 	}
 
-	info, exists, err := filesystem.GetProperty(ctx, propertyName)
+	info, err := getInfo(ctx, filesystem)
 	if err != nil {
 		return types.BuildInfo{}, err
 	}
-	if !exists {
-		return types.BuildInfo{}, errors.Errorf("property %s does not exist on filesystem %s", propertyName,
-			filesystem.Info.Name)
-	}
 
 	var buildInfo types.BuildInfo
 	if err := json.Unmarshal([]byte(info), &buildInfo); err != nil {
@@ -202,6 +206,11 @@ func (d *zfsDriver) StoreManifest(ctx context.Context, manifest types.ImageManif
 	}
 	info.Params = manifest.Params
 	info.Boots = manifest.Boots
+	info.Usage = manifest.Usage
+	info.Tests = manifest.Tests
+	info.Labels = manifest.Labels
+	info.Base = manifest.Base
+	info.Provenance = manifest.Provenance
 	return d.setInfo(ctx, info)
 }
 
@@ -291,7 +300,73 @@ func (d *zfsDriver) setInfo(ctx context.Context, info types.BuildInfo) error {
 		return err
 	}
 
-	return filesystem.SetProperty(ctx, propertyName, string(must.Bytes(json.Marshal(info))))
+	return setInfo(ctx, filesystem, string(must.Bytes(json.Marshal(info))))
+}
+
+// setInfo stores info in filesystem properties. If info exceeds the limit of the single property, it is split into
+// chunks stored in properties <propertyName>.<n>, and the main property is prefixed with the number of chunks.
+func setInfo(ctx context.Context, filesystem *zfs.Filesystem, info string) error {
+	if len(info) <= propertyLimit {
+		return filesystem.SetProperty(ctx, propertyName, info)
+	}
+
+	var chunks []string
+	for len(info) > 0 {
+		end := min(propertyLimit, len(info))
+		// Chunks are split on rune boundaries to keep them valid UTF-8 strings.
+		for end < len(info) && !utf8.RuneStart(info[end]) {
+			end--
+		}
+		chunks = append(chunks, info[:end])
+		info = info[end:]
+	}
+
+	// Main property is set as the last one, so readers never see incomplete info.
+	for i := len(chunks) - 1; i > 0; i-- {
+		if err := filesystem.SetProperty(ctx, propertyName+"."+strconv.Itoa(i), chunks[i]); err != nil {
+			return err
+		}
+	}
+	return filesystem.SetProperty(ctx, propertyName, strconv.Itoa(len(chunks))+":"+chunks[0])
+}
+
+func getInfo(ctx context.Context, filesystem *zfs.Filesystem) (string, error) {
+	info, exists, err := filesystem.GetProperty(ctx, propertyName)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		return "", errors.Errorf("property %s does not exist on filesystem %s", propertyName,
+			filesystem.Info.Name)
+	}
+
+	// Info stored in single property is a JSON object, otherwise it starts with the number of chunks.
+	if strings.HasPrefix(info, "{") {
+		return info, nil
+	}
+	countStr, chunk, ok := strings.Cut(info, ":")
+	if !ok {
+		return "", errors.Errorf("property %s on filesystem %s is invalid", propertyName, filesystem.Info.Name)
+	}
+	count, err := strconv.Atoi(countStr)
+	if err != nil {
+		return "", errors.Wrapf(err, "property %s on filesystem %s is invalid", propertyName, filesystem.Info.Name)
+	}
+
+	res := strings.Builder{}
+	res.WriteString(chunk)
+	for i := 1; i < count; i++ {
+		name := propertyName + "." + strconv.Itoa(i)
+		chunk, exists, err := filesystem.GetProperty(ctx, name)
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			return "", errors.Errorf("property %s does not exist on filesystem %s", name, filesystem.Info.Name)
+		}
+		res.WriteString(chunk)
+	}
+	return res.String(), nil
 }
 
 func inTags(slice types.Tags, el types.Tag) bool {
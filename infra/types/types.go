@@ -7,6 +7,7 @@ import (
 	"hash/crc32"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -161,7 +162,11 @@ func (bid BuildID) IsValidType(buildType BuildType) bool {
 	return bid.Type() == buildType
 }
 
-var validRegExp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9\-_.:]*$`)
+var (
+	validRegExp         = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9\-_.:]*$`)
+	baseReferenceRegExp = regexp.MustCompile(`^([a-zA-Z][a-zA-Z0-9+\-.]*):(.*)$`)
+	schemeRegExp        = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9+\-.]*://`)
+)
 
 // Tag is the tag of build.
 type Tag string
@@ -239,6 +244,22 @@ func (bk BuildKey) IsValid() bool {
 	return IsNameValid(bk.Name) && bk.Tag.IsValid()
 }
 
+// IsBaseReference returns true if string references base image using scheme, e.g. docker://fedora:40.
+// The // is required here, otherwise build keys like fedora:40 would be taken for references.
+func IsBaseReference(str string) bool {
+	return schemeRegExp.MatchString(str)
+}
+
+// ParseBaseReference splits reference to base image into scheme and location.
+// Leading // of the location is dropped, so both docker://fedora and docker:fedora are accepted.
+func ParseBaseReference(ref string) (scheme string, location string, err error) {
+	match := baseReferenceRegExp.FindStringSubmatch(ref)
+	if match == nil {
+		return "", "", errors.Errorf("base image reference '%s' is invalid, expected <scheme>:<location>", ref)
+	}
+	return strings.ToLower(match[1]), strings.TrimPrefix(match[2], "//"), nil
+}
+
 // Params is a list of params configured on image.
 type Params []string
 
@@ -256,12 +277,235 @@ func (p Params) String() string {
 	return strings.Join(values, ", ")
 }
 
+// Labels are arbitrary key-value metadata attached to the build.
+type Labels map[string]string
+
+// Clone returns copy of labels.
+func (l Labels) Clone() Labels {
+	if l == nil {
+		return nil
+	}
+	res := make(Labels, len(l))
+	for k, v := range l {
+		res[k] = v
+	}
+	return res
+}
+
+func (l Labels) String() string {
+	values := make([]string, 0, len(l))
+	for k, v := range l {
+		values = append(values, k+"="+v)
+	}
+	sort.Strings(values)
+
+	return strings.Join(values, ", ")
+}
+
+// ParseLabel parses label in the form of key=value. If value is not required, key alone is accepted too.
+func ParseLabel(label string, valueRequired bool) (string, string, error) {
+	key, value, hasValue := strings.Cut(label, "=")
+	if key == "" || strings.ContainsAny(key, " \t\n") {
+		return "", "", errors.Errorf("label key in '%s' is invalid", label)
+	}
+	if valueRequired && !hasValue {
+		return "", "", errors.Errorf("label '%s' must be in the form of key=value", label)
+	}
+	return key, value, nil
+}
+
+// Limits defines resources available to build commands. Zero value means no limit.
+type Limits struct {
+	// CPUs is the number of CPUs commands may use.
+	CPUs float64
+
+	// Memory is the maximum amount of memory in bytes.
+	Memory uint64
+
+	// Pids is the maximum number of processes.
+	Pids uint64
+}
+
+// Merge returns limits where fields set in other override the ones set in l.
+func (l Limits) Merge(other Limits) Limits {
+	if other.CPUs > 0 {
+		l.CPUs = other.CPUs
+	}
+	if other.Memory > 0 {
+		l.Memory = other.Memory
+	}
+	if other.Pids > 0 {
+		l.Pids = other.Pids
+	}
+	return l
+}
+
+// IsZero returns true if no limit is set.
+func (l Limits) IsZero() bool {
+	return l == Limits{}
+}
+
+// Usage stores peak resource usage of the build.
+type Usage struct {
+	// CPU is the total CPU time consumed.
+	CPU time.Duration
+
+	// MemoryPeak is the highest amount of memory used, in bytes.
+	MemoryPeak uint64
+
+	// PidsPeak is the highest number of processes running at the same time.
+	PidsPeak uint64
+}
+
+func (u Usage) String() string {
+	if u == (Usage{}) {
+		return ""
+	}
+	return fmt.Sprintf("cpu: %s, memory: %s, pids: %d", u.CPU.Round(time.Millisecond), FormatMemory(u.MemoryPeak),
+		u.PidsPeak)
+}
+
+var memoryUnits = map[string]uint64{
+	"":  1,
+	"b": 1,
+	"k": 1 << 10,
+	"m": 1 << 20,
+	"g": 1 << 30,
+	"t": 1 << 40,
+}
+
+// ParseMemory parses memory size like 512M or 4GiB into number of bytes.
+func ParseMemory(value string) (uint64, error) {
+	v := strings.ToLower(strings.TrimSpace(value))
+	v = strings.TrimSuffix(strings.TrimSuffix(v, "ib"), "b")
+	pos := strings.IndexFunc(v, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if pos < 0 {
+		pos = len(v)
+	}
+	unit, exists := memoryUnits[v[pos:]]
+	if !exists || pos == 0 {
+		return 0, errors.Errorf("invalid memory size '%s'", value)
+	}
+	number, err := strconv.ParseFloat(v[:pos], 64)
+	if err != nil || number <= 0 {
+		return 0, errors.Errorf("invalid memory size '%s'", value)
+	}
+	return uint64(number * float64(unit)), nil
+}
+
+// FormatMemory returns human-readable representation of memory size.
+func FormatMemory(size uint64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := uint64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// TestResult is the result of the test executed by TEST command.
+type TestResult struct {
+	// Test is the tested command.
+	Test string
+
+	// Passed is true if test succeeded.
+	Passed bool
+
+	// Error is the reason of failure.
+	Error string
+
+	// Duration is the time test took.
+	Duration time.Duration
+}
+
+// Step describes executed build step.
+type Step struct {
+	// Step is the number of the step.
+	Step int
+
+	// Source is the location where the command is defined.
+	Source string
+
+	// Command is the executed command.
+	Command string
+
+	// Duration is the time step took.
+	Duration time.Duration
+}
+
+// SpecCommand is the command image is defined by.
+type SpecCommand struct {
+	// Source is the location where the command is defined.
+	Source string
+
+	// Command is the command.
+	Command string
+}
+
+// Provenance describes how the image was produced.
+type Provenance struct {
+	// SpecFile is the path to the spec file image was built from.
+	SpecFile string
+
+	// Includes are the paths to the files included by the spec file, in the order of inclusion.
+	Includes []string
+
+	// SpecDigest is the digest of the content of the spec file and included files.
+	SpecDigest string
+
+	// From is the build key of the parent image.
+	From BuildKey
+
+	// FromBuildID is the ID of the build resolved for the parent image.
+	FromBuildID BuildID
+
+	// FromStage is true if the parent image is the build stage, untagged once the image is built.
+	FromStage bool
+
+	// Commands are all the commands image is defined by, with INCLUDEs expanded and FROM resolved
+	// to the build key of the parent image.
+	Commands []SpecCommand
+
+	// Steps are the executed commands, with INCLUDEs expanded.
+	Steps []Step
+
+	// BuilderVersion is the version of osman used to build the image.
+	BuilderVersion string
+
+	// Host is the name of the host where image was built.
+	Host string
+
+	// User is the name of the user who built the image.
+	User string
+}
+
+// BaseSource describes the source base image is initialized from.
+type BaseSource struct {
+	// Reference is the reference to the base image in form <scheme>:<location>, e.g. tar:/srv/rootfs.tar.zst.
+	Reference string
+
+	// Digest is the digest of the content base image was initialized from, e.g. sha256:<hex>.
+	Digest string
+}
+
 // ImageManifest contains info about built image.
 type ImageManifest struct {
 	BuildID BuildID
 	BasedOn BuildID
 	Params  Params
 	Boots   []Boot
+	Usage   Usage
+	Tests   []TestResult
+	Labels  Labels
+
+	Base       *BaseSource
+	Provenance *Provenance
 }
 
 // BuildInfo stores all the information about build.
@@ -273,5 +517,12 @@ type BuildInfo struct {
 	Tags      Tags
 	Params    Params
 	Boots     []Boot
-	Mounted   string
+	Usage     Usage
+	Tests     []TestResult
+	Labels    Labels
+
+	Base       *BaseSource
+	Provenance *Provenance
+
+	Mounted string
 }
@@ -0,0 +1,165 @@
+package infra
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/outofforest/osman/infra/runner"
+)
+
+type passwdEntry struct {
+	Name string
+	UID  uint32
+	GID  uint32
+	Home string
+}
+
+type groupEntry struct {
+	Name    string
+	GID     uint32
+	Members []string
+}
+
+// resolveUser resolves user specified as `user[:group]` against /etc/passwd and /etc/group files
+// of the image mounted at root.
+func resolveUser(root, spec string) (*runner.User, error) {
+	userSpec, groupSpec, hasGroup := strings.Cut(spec, ":")
+	if userSpec == "" || (hasGroup && groupSpec == "") {
+		return nil, errors.Errorf("invalid user specification '%s'", spec)
+	}
+
+	users, err := readPasswd(root)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := readGroup(root)
+	if err != nil {
+		return nil, err
+	}
+
+	var user *passwdEntry
+	uid, uidErr := strconv.ParseUint(userSpec, 10, 32)
+	for i, u := range users {
+		if u.Name == userSpec || (uidErr == nil && u.UID == uint32(uid)) {
+			user = &users[i]
+			break
+		}
+	}
+	if user == nil {
+		return nil, errors.Errorf("user '%s' does not exist in /etc/passwd of the image", userSpec)
+	}
+
+	res := &runner.User{
+		Name: user.Name,
+		Home: user.Home,
+		UID:  user.UID,
+		GID:  user.GID,
+	}
+
+	if hasGroup {
+		gid, gidErr := strconv.ParseUint(groupSpec, 10, 32)
+		found := false
+		for _, g := range groups {
+			if g.Name == groupSpec || (gidErr == nil && g.GID == uint32(gid)) {
+				res.GID = g.GID
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, errors.Errorf("group '%s' does not exist in /etc/group of the image", groupSpec)
+		}
+		return res, nil
+	}
+
+	for _, g := range groups {
+		if g.GID == res.GID {
+			continue
+		}
+		for _, m := range g.Members {
+			if m == user.Name {
+				res.Groups = append(res.Groups, g.GID)
+				break
+			}
+		}
+	}
+	return res, nil
+}
+
+func readPasswd(root string) ([]passwdEntry, error) {
+	var entries []passwdEntry
+	err := readColonFile(root, "/etc/passwd", 7, func(fields []string) error {
+		uid, err := strconv.ParseUint(fields[2], 10, 32)
+		if err != nil {
+			return errors.Wrapf(err, "invalid uid of user '%s'", fields[0])
+		}
+		gid, err := strconv.ParseUint(fields[3], 10, 32)
+		if err != nil {
+			return errors.Wrapf(err, "invalid gid of user '%s'", fields[0])
+		}
+		entries = append(entries, passwdEntry{
+			Name: fields[0],
+			UID:  uint32(uid),
+			GID:  uint32(gid),
+			Home: fields[5],
+		})
+		return nil
+	})
+	return entries, err
+}
+
+func readGroup(root string) ([]groupEntry, error) {
+	var entries []groupEntry
+	err := readColonFile(root, "/etc/group", 4, func(fields []string) error {
+		gid, err := strconv.ParseUint(fields[2], 10, 32)
+		if err != nil {
+			return errors.Wrapf(err, "invalid gid of group '%s'", fields[0])
+		}
+		var members []string
+		if fields[3] != "" {
+			members = strings.Split(fields[3], ",")
+		}
+		entries = append(entries, groupEntry{
+			Name:    fields[0],
+			GID:     uint32(gid),
+			Members: members,
+		})
+		return nil
+	})
+	return entries, err
+}
+
+func readColonFile(root, path string, numOfFields int, fn func(fields []string) error) error {
+	file, err := ResolveInRoot(root, path)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return errors.Errorf("file %s does not exist in the image", path)
+		}
+		return errors.WithStack(err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ":")
+		if len(fields) < numOfFields {
+			continue
+		}
+		if err := fn(fields); err != nil {
+			return err
+		}
+	}
+	return errors.WithStack(scanner.Err())
+}
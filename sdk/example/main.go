@@ -0,0 +1,52 @@
+// Example binary embedding osman builder with images defined in Go code.
+//
+// Build any of the images with:
+//
+//	sudo go run ./sdk/example build example-nginx
+package main
+
+import (
+	"time"
+
+	"github.com/outofforest/osman/infra/description"
+	"github.com/outofforest/osman/infra/types"
+	"github.com/outofforest/osman/sdk"
+)
+
+var base = types.NewBuildKey("example-base", description.DefaultTag)
+
+func main() {
+	sdk.Main(images()...)
+}
+
+func images() []*description.Descriptor {
+	images := []*description.Descriptor{
+		description.Describe(base.Name, types.Tags{base.Tag},
+			description.From(types.NewBuildKey("fedora", "40")),
+			dnfInstall("openssh-server"),
+			description.Run("systemctl enable sshd"),
+			description.Label("purpose", "example"),
+			description.Test("systemctl is-enabled sshd"),
+		),
+	}
+
+	// Loops and helpers compose images like any other Go code.
+	for _, service := range []string{"nginx", "redis"} {
+		images = append(images, description.Describe("example-"+service, types.Tags{description.DefaultTag},
+			description.From(base),
+			dnfInstall(service),
+			description.Run("systemctl enable "+service),
+			description.Label("service", service),
+			description.TestExec([]string{"systemctl", "is-enabled", service}),
+		))
+	}
+	return images
+}
+
+// dnfInstall installs packages using dnf cache shared between builds.
+func dnfInstall(packages ...string) description.Command {
+	return description.RunExec(append([]string{"dnf", "install", "-y"}, packages...),
+		description.WithMount(description.CacheMount("", "/var/cache/dnf", description.SharingLocked)),
+		description.WithTimeout(30*time.Minute),
+	)
+}
@@ -0,0 +1,126 @@
+// Package sdk runs osman with images defined in Go code.
+//
+// Images are defined using constructors of description package, e.g.:
+//
+//	base := description.Describe("base", types.Tags{description.DefaultTag},
+//		description.From(types.NewBuildKey("fedora", "40")),
+//		description.Run("dnf install -y openssh-server"),
+//	)
+//
+// and passed to Main. Registered images may be used as parents of spec files and built by passing their names
+// to the build command instead of spec files.
+package sdk
+
+import (
+	"context"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/ridge/must"
+	"github.com/spf13/cobra"
+
+	"github.com/outofforest/ioc/v2"
+	"github.com/outofforest/isolator/executor"
+	"github.com/outofforest/isolator/wire"
+	"github.com/outofforest/logger"
+	"github.com/outofforest/osman"
+	"github.com/outofforest/osman/commands"
+	"github.com/outofforest/osman/infra"
+	"github.com/outofforest/osman/infra/base"
+	"github.com/outofforest/osman/infra/description"
+	"github.com/outofforest/osman/infra/export"
+	"github.com/outofforest/osman/infra/format"
+	"github.com/outofforest/osman/infra/parser"
+	"github.com/outofforest/osman/infra/progress"
+	"github.com/outofforest/osman/infra/runner"
+	"github.com/outofforest/osman/infra/storage"
+	"github.com/outofforest/run"
+)
+
+// Main runs osman command line interface with images registered in the repository.
+// Binary calling it is used to execute build commands in isolation too, so Main must be called at the very
+// beginning of the main function. It panics if any image is defined incorrectly.
+func Main(images ...*description.Descriptor) {
+	for _, img := range images {
+		must.OK(img.Validate())
+	}
+
+	run.New().
+		WithContainerBuilder(iocBuilder(images)).
+		WithFlavour(executor.NewFlavour(executor.Config{
+			Router: executor.NewRouter().
+				RegisterHandler(wire.Execute{}, executor.ExecuteHandler).
+				RegisterHandler(runner.Execute{}, runner.ExecuteHandler).
+				RegisterHandler(runner.Ping{}, runner.PingHandler).
+				RegisterHandler(runner.Copy{}, runner.CopyHandler).
+				RegisterHandler(runner.Unpack{}, runner.UnpackHandler).
+				RegisterHandler(wire.InflateDockerImage{}, executor.NewInflateDockerImageHandler()),
+		})).
+		Run(context.Background(), "osman", func(ctx context.Context, rootCmd *cobra.Command) error {
+			err := rootCmd.Execute()
+			var exitErr commands.ExitError
+			if errors.As(err, &exitErr) {
+				// Command has already finished and cleaned up, so process exits with its exit code.
+				_ = logger.Get(ctx).Sync()
+				os.Exit(exitErr.Code)
+			}
+			return err
+		})
+}
+
+func iocBuilder(images []*description.Descriptor) func(c *ioc.Container) {
+	return func(c *ioc.Container) {
+		c.Singleton(commands.NewCmdFactory)
+		c.Singleton(base.NewResolvingInitializer)
+		c.SingletonNamed("docker", base.NewDockerInitializer)
+		c.SingletonNamed("dir", base.NewDirInitializer)
+		c.SingletonNamed("oci", base.NewOCIInitializer)
+		c.SingletonNamed("osman", base.NewOsmanInitializer)
+		c.SingletonNamed("scratch", base.NewScratchInitializer)
+		c.SingletonNamed("tar", base.NewTarInitializer)
+		c.Singleton(func() *infra.Repository {
+			repo := infra.NewRepository()
+			for _, img := range images {
+				repo.Store(img)
+			}
+			return repo
+		})
+		c.Transient(infra.NewBuilder)
+
+		c.Singleton(storage.Resolve)
+		c.SingletonNamed("zfs", storage.NewZFSDriver)
+
+		c.Singleton(parser.NewResolvingParser)
+		c.SingletonNamed("spec", parser.NewSpecFileParser)
+
+		c.Singleton(progress.Resolve)
+		c.SingletonNamed("plain", progress.NewPlainReporter)
+		c.SingletonNamed("json", progress.NewJSONReporter)
+
+		c.Singleton(export.Resolve)
+		c.SingletonNamed("oci", export.NewOCIExporter)
+		c.SingletonNamed("tar", export.NewTarExporter)
+		c.SingletonNamed("raw", osman.NewRawExporter)
+		c.SingletonNamed("qcow2", osman.NewQCOW2Exporter)
+
+		c.Singleton(format.Resolve)
+		c.SingletonNamed("table", format.NewTableFormatter)
+		c.SingletonNamed("json", format.NewJSONFormatter)
+
+		c.Singleton(commands.NewRootCommand)
+		c.SingletonNamed("build", commands.NewBuildCommand)
+		c.SingletonNamed("mount", commands.NewMountCommand)
+		c.SingletonNamed("start", commands.NewStartCommand)
+		c.SingletonNamed("stop", commands.NewStopCommand)
+		c.SingletonNamed("list", commands.NewListCommand)
+		c.SingletonNamed("drop", commands.NewDropCommand)
+		c.SingletonNamed("tag", commands.NewTagCommand)
+		c.SingletonNamed("cache", commands.NewCacheCommand)
+		c.SingletonNamed("logs", commands.NewLogsCommand)
+		c.SingletonNamed("shell", commands.NewShellCommand)
+		c.SingletonNamed("run", commands.NewRunCommand)
+		c.SingletonNamed("inspect", commands.NewInspectCommand)
+		c.SingletonNamed("export", commands.NewExportCommand)
+		c.SingletonNamed("status", commands.NewStatusCommand)
+	}
+}
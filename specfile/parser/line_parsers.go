@@ -49,6 +49,16 @@ func parseStringsWhitespaceDelimited(rest string, _ *directives) (*Node, map[str
 	return rootnode, nil, nil
 }
 
+// parseString just wraps the string in quotes and returns a working node.
+func parseString(rest string, _ *directives) (*Node, map[string]bool, error) {
+	if rest == "" {
+		return nil, nil, nil
+	}
+	n := &Node{}
+	n.Value = rest
+	return n, nil, nil
+}
+
 // parseMaybeJSONToList determines if the argument appears to be a JSON array. If
 // so, passes to parseJSON; if not, attempts to parse it as a whitespace
 // delimited string.
@@ -65,6 +75,86 @@ func parseMaybeJSONToList(rest string, d *directives) (*Node, map[string]bool, e
 	return parseStringsWhitespaceDelimited(rest, d)
 }
 
+// parseMaybeJSONToWords determines if the argument appears to be a JSON array. If
+// so, passes to parseJSON; if not, splits it into words the way shell does, so
+// quoted values may contain whitespaces, e.g. key="value with spaces".
+func parseMaybeJSONToWords(rest string, d *directives) (*Node, map[string]bool, error) {
+	node, attrs, err := parseJSON(rest, d)
+
+	if err == nil {
+		return node, attrs, nil
+	}
+	if errors.Is(err, errDockerfileNotStringArray) {
+		return nil, nil, err
+	}
+
+	words, err := parseWords(rest)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var top, prev *Node
+	for _, word := range words {
+		node := &Node{Value: word}
+		if prev == nil {
+			top = node
+		} else {
+			prev.Next = node
+		}
+		prev = node
+	}
+	return top, nil, nil
+}
+
+// parseWords splits the string into whitespace-delimited words. Whitespaces
+// inside single or double quotes don't split words and backslash escapes the
+// next character, except inside single quotes. Quotes are removed from words.
+func parseWords(rest string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	var quote rune
+	inWord := false
+	escaped := false
+	for _, ch := range rest {
+		switch {
+		case escaped:
+			word.WriteRune(ch)
+			escaped = false
+		case ch == '\\' && quote != '\'':
+			escaped = true
+			inWord = true
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+			} else {
+				word.WriteRune(ch)
+			}
+		case ch == '"' || ch == '\'':
+			quote = ch
+			inWord = true
+		case unicode.IsSpace(ch):
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(ch)
+			inWord = true
+		}
+	}
+
+	switch {
+	case quote != 0:
+		return nil, errors.Errorf("unterminated quote %c in '%s'", quote, rest)
+	case escaped:
+		return nil, errors.Errorf("nothing to escape at the end of '%s'", rest)
+	case inWord:
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
 // parseJSON converts JSON arrays to an AST.
 func parseJSON(rest string, _ *directives) (*Node, map[string]bool, error) {
 	rest = strings.TrimLeftFunc(rest, unicode.IsSpace)
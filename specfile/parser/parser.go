@@ -182,6 +182,12 @@ func init() {
 		"run":     parseMaybeJSON,
 		"include": parseStringsWhitespaceDelimited,
 		"boot":    parseMaybeJSONToList,
+		"workdir": parseString,
+		"user":    parseString,
+		"shell":   parseMaybeJSON,
+		"copy":    parseMaybeJSONToList,
+		"test":    parseMaybeJSON,
+		"label":   parseMaybeJSONToWords,
 	}
 }
 